@@ -0,0 +1,286 @@
+package main
+
+import (
+	"lfm_lookout/internal/botcmds"
+	"lfm_lookout/internal/lodb"
+	"lfm_lookout/internal/matcher"
+	"lfm_lookout/internal/scheduler"
+
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	dg "github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+const (
+	// The most messages a board spreads its groups over.
+	boardPagesMax = 3
+	// The most characters of groups a board's message holds.
+	boardPageLen = 1900
+	// The most groups a board shows.
+	boardGroupsMax = 200
+	// The pause between edits of a board's pages, to stay clear of Discord's
+	// rate limits.
+	boardEditGap = time.Millisecond * 250
+)
+
+type boardRender struct {
+	board lodb.Board
+	pages []*dg.MessageEmbed
+	// hash identifies the board's contents, apart from the time rendered.
+	hash string
+}
+
+// updateBoards renders every board from the index, then edits the boards'
+// messages in the background. If the previous tick's edits are still in
+// progress, the boards are left until the next tick.
+func (env *LookoutEnv) updateBoards(session botcmds.Session, index scheduler.Index) {
+	botEnv := env.Env
+	if !atomic.CompareAndSwapInt32(&env.boardsBusy, 0, 1) {
+		botEnv.Log.Warn("Board edits from the previous tick are still in progress.")
+		return
+	}
+	boards, err := botEnv.Repo.FindBoards()
+	if err != nil {
+		botEnv.Log.Error(
+			"Error while retrieving boards.",
+			zap.Error(err))
+		atomic.StoreInt32(&env.boardsBusy, 0)
+		return
+	}
+	// Render while the index is still open.
+	now := time.Now()
+	renders := make([]boardRender, 0, len(boards))
+	for _, b := range boards {
+		matches, err := index.Match(b.Query, matcher.Filter{Packs: b.Packs}, boardGroupsMax)
+		if err != nil {
+			botEnv.Log.Warn(
+				"Board resulted in error upon searching.",
+				zap.String("guild", b.GuildID),
+				zap.String("query", b.Query),
+				zap.Error(err))
+			continue
+		}
+		renders = append(renders, renderBoard(b, matches, now))
+	}
+	// The edits are paced from outside the outbox, so that its workers
+	// aren't held up between them.
+	env.background(func() {
+		env.editBoards(session, renders)
+	})
+}
+
+// editBoards queues the edits to the boards whose groups have changed since
+// their last edit, one board at a time, pacing them to stay clear of
+// Discord's rate limits. It stops early if the bot is shutting down.
+func (env *LookoutEnv) editBoards(session botcmds.Session, renders []boardRender) {
+	defer atomic.StoreInt32(&env.boardsBusy, 0)
+	pace := time.NewTicker(boardEditGap)
+	defer pace.Stop()
+	// Hashes are kept by the board's first message, as a board's ID may be
+	// reused by one made after it's removed, and only for current boards.
+	hashes := make(map[string]string, len(renders))
+	defer func() {
+		env.boardHashes = hashes
+	}()
+	for _, r := range renders {
+		if atomic.LoadInt32(&env.closing) == 1 {
+			return
+		}
+		var key string
+		if len(r.board.MessageIDs) > 0 {
+			key = r.board.MessageIDs[0]
+		}
+		// Skip boards whose groups haven't changed since the last edit.
+		if key != "" && env.boardHashes[key] == r.hash {
+			hashes[key] = r.hash
+			continue
+		}
+		var err error
+		done := make(chan struct{})
+		queued := env.queue(func() {
+			defer close(done)
+			err = env.editBoard(session, r)
+		})
+		if !queued {
+			return
+		}
+		<-done
+		if err != nil {
+			env.Env.Log.Warn(
+				"Error editing board.",
+				zap.String("guild", r.board.GuildID),
+				zap.Int("board", r.board.ID),
+				zap.Error(err))
+		} else if key != "" {
+			hashes[key] = r.hash
+		}
+		for range r.pages {
+			<-pace.C
+		}
+	}
+}
+
+// editBoard edits the board's messages to show its rendered pages. Messages
+// are sent for pages the board doesn't have yet, or whose messages have been
+// deleted, and the board's messages are saved if any were sent. Nothing is
+// sent for a board removed since it was rendered.
+func (env *LookoutEnv) editBoard(session botcmds.Session, r boardRender) error {
+	b := r.board
+	var sent []string
+	for i, page := range r.pages {
+		if i < len(b.MessageIDs) {
+			_, err := session.ChannelMessageEditEmbed(b.ChannelID, b.MessageIDs[i], page)
+			if err == nil {
+				continue
+			}
+			var restErr *dg.RESTError
+			if !errors.As(err, &restErr) || restErr.Message == nil || restErr.Message.Code != dg.ErrCodeUnknownMessage {
+				return err
+			}
+		}
+		// The message may be gone because the board was removed.
+		current, err := env.Env.Repo.FindBoard(b.GuildID, b.ID)
+		if err == lodb.ErrBoardNotFound || (err == nil && current.ChannelID != b.ChannelID) {
+			env.deleteMessages(session, b.ChannelID, sent)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		m, err := session.ChannelMessageSendEmbed(b.ChannelID, page)
+		if err != nil {
+			return err
+		}
+		if i < len(b.MessageIDs) {
+			b.MessageIDs[i] = m.ID
+		} else {
+			b.MessageIDs = append(b.MessageIDs, m.ID)
+		}
+		sent = append(sent, m.ID)
+	}
+	if len(sent) == 0 {
+		return nil
+	}
+	err := env.Env.Repo.UpdateBoard(b)
+	if err == lodb.ErrBoardNotFound {
+		// Removed while the messages were being sent.
+		env.deleteMessages(session, b.ChannelID, sent)
+		return nil
+	}
+	return err
+}
+
+// deleteMessages deletes messages sent for a board which has been removed.
+func (env *LookoutEnv) deleteMessages(session botcmds.Session, channelID string, messageIDs []string) {
+	for _, id := range messageIDs {
+		if err := session.ChannelMessageDelete(channelID, id); err != nil {
+			env.Env.Log.Warn(
+				"Error deleting a removed board's message.",
+				zap.Error(err))
+		}
+	}
+}
+
+// renderBoard lays the matched groups out over as many pages as the board
+// has messages, adding pages as needed up to boardPagesMax.
+func renderBoard(b lodb.Board, matches []matcher.SearchableGroup, now time.Time) boardRender {
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Group.MinLevel > matches[j].Group.MinLevel
+	})
+	// Count groups per level band.
+	bands := []struct {
+		name     string
+		min, max int
+		count    int
+	}{
+		{name: "Levels 1-9", min: 1, max: 9},
+		{name: "Levels 10-19", min: 10, max: 19},
+		{name: "Levels 20-29", min: 20, max: 29},
+		{name: "Levels 30+", min: 30, max: 1 << 16},
+	}
+	for _, sGroup := range matches {
+		for i := range bands {
+			if sGroup.Group.MinLevel <= bands[i].max && sGroup.Group.MaxLevel >= bands[i].min {
+				bands[i].count++
+			}
+		}
+	}
+	fields := make([]*dg.MessageEmbedField, len(bands))
+	for i, band := range bands {
+		fields[i] = &dg.MessageEmbedField{Name: band.name, Value: fmt.Sprintf("%d", band.count), Inline: true}
+	}
+	// Fill pages with a line per group.
+	var pages []string
+	var page strings.Builder
+	shown := 0
+	for _, sGroup := range matches {
+		line := boardLine(sGroup)
+		if page.Len()+len(line) > boardPageLen {
+			if len(pages) == boardPagesMax-1 {
+				break
+			}
+			pages = append(pages, page.String())
+			page.Reset()
+		}
+		page.WriteString(line)
+		shown++
+	}
+	if shown < len(matches) {
+		fmt.Fprintf(&page, "*...and %d more.*", len(matches)-shown)
+	}
+	if len(matches) == 0 {
+		page.WriteString("No groups right now.")
+	}
+	pages = append(pages, page.String())
+	for len(pages) < len(b.MessageIDs) && len(pages) < boardPagesMax {
+		pages = append(pages, "No more groups.")
+	}
+
+	title := fmt.Sprintf("%s LFM Board", b.Server)
+	hash := fmt.Sprint(title, pages, bands)
+	embeds := make([]*dg.MessageEmbed, len(pages))
+	for i, p := range pages {
+		embeds[i] = &dg.MessageEmbed{
+			Title:       title,
+			Description: p,
+			Footer:      &dg.MessageEmbedFooter{Text: fmt.Sprintf("Board %d | Page %d of %d | Last changed", b.ID, i+1, len(pages))},
+			Timestamp:   now.Format(time.RFC3339),
+		}
+		if len(pages) > 1 {
+			embeds[i].Title = fmt.Sprintf("%s (%d/%d)", title, i+1, len(pages))
+		}
+	}
+	embeds[0].Fields = fields
+	return boardRender{board: b, pages: embeds, hash: hash}
+}
+
+// boardLine formats a group onto a single line of a board.
+func boardLine(sGroup matcher.SearchableGroup) string {
+	g := sGroup.Group
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%d-%d** ", g.MinLevel, g.MaxLevel)
+	if g.Quest.Name != "" {
+		fmt.Fprintf(&b, "%s ", g.Quest.Name)
+	}
+	if g.Difficulty != "" {
+		fmt.Fprintf(&b, "(%s) ", g.Difficulty)
+	}
+	fmt.Fprintf(&b, "| %d/%d | #%d", sGroup.Members, sGroup.Capacity, g.Id)
+	if g.AdventureActive != 0 {
+		fmt.Fprintf(&b, " | *Active: %dm*", g.AdventureActive)
+	}
+	if g.Comment != "" {
+		comment := g.Comment
+		if r := []rune(comment); len(r) > 80 {
+			comment = string(r[:77]) + "..."
+		}
+		fmt.Fprintf(&b, "\n> %s", comment)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
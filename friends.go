@@ -0,0 +1,107 @@
+package main
+
+import (
+	"lfm_lookout/internal/botcmds"
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/matcher"
+
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// presence is a player seen in a group.
+type presence struct {
+	name   string
+	guild  string
+	leader bool
+	group  matcher.SearchableGroup
+}
+
+// presences maps the players of every group, by server and lowercased name,
+// to where they were seen.
+func presences(auditMap botenv.AuditMap) map[string]presence {
+	players := make(map[string]presence)
+	for server, serverMap := range auditMap.Map {
+		for _, sGroup := range serverMap {
+			add := func(name, guild string, leader bool) {
+				if name == "" {
+					return
+				}
+				key := server + "/" + strings.ToLower(name)
+				players[key] = presence{name: name, guild: guild, leader: leader, group: sGroup}
+			}
+			add(sGroup.Group.Leader.Name, sGroup.Group.Leader.Guild, true)
+			for _, m := range sGroup.Group.Members {
+				add(m.Name, m.Guild, false)
+			}
+		}
+	}
+	return players
+}
+
+// runFriends notifies users of the players on their watchlists who have led
+// or joined a group since the previous audit, and returns the number of
+// watchlists. Players who have opted out are never notified of.
+func (env *LookoutEnv) runFriends(session botcmds.Session, prevAudit, currAudit botenv.AuditMap) int {
+	botEnv := env.Env
+	lists, err := botEnv.Repo.FindAllFriends()
+	if err != nil {
+		botEnv.Log.Error(
+			"Error while retrieving watchlists.",
+			zap.Error(err))
+		return 0
+	}
+	if len(lists) == 0 {
+		return 0
+	}
+	optOuts, err := botEnv.Repo.FindOptOuts()
+	if err != nil {
+		botEnv.Log.Error(
+			"Error while retrieving opt-outs.",
+			zap.Error(err))
+		return len(lists)
+	}
+	prev := presences(prevAudit)
+	var arrivals []presence
+	for key, p := range presences(currAudit) {
+		if optOuts.Has(p.group.Server, p.name) {
+			continue
+		}
+		if before, ok := prev[key]; ok && before.group.Key() == p.group.Key() && before.leader == p.leader {
+			continue
+		}
+		arrivals = append(arrivals, p)
+	}
+	for _, f := range lists {
+		sent := 0
+		for _, p := range arrivals {
+			if sent == searchSize {
+				break
+			}
+			if !containsFold(f.Players, p.name) && !(p.guild != "" && containsFold(f.Guilds, p.guild)) {
+				continue
+			}
+			label := fmt.Sprintf("%s joined", p.name)
+			if p.leader {
+				label = fmt.Sprintf("%s leads", p.name)
+			}
+			if p.guild != "" {
+				label = fmt.Sprintf("%s <%s>", label, p.guild)
+			}
+			env.queueMatches(session, f.Return(), label, []matcher.SearchableGroup{p.group}, "")
+			sent++
+		}
+	}
+	return len(lists)
+}
+
+func containsFold(s []string, v string) bool {
+	for i := range s {
+		if strings.EqualFold(s[i], v) {
+			return true
+		}
+	}
+	return false
+}
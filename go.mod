@@ -8,6 +8,8 @@ require (
 	github.com/dgraph-io/badger/v3 v3.2011.1
 	github.com/mattn/go-sqlite3 v1.14.5
 	github.com/natefinch/lumberjack v2.0.0+incompatible
+	github.com/prometheus/client_golang v1.11.0
 	github.com/sirupsen/logrus v1.7.0
 	go.uber.org/zap v1.17.0
+	golang.org/x/image v0.0.0-20210628002857-a66eb6448b8d
 )
@@ -0,0 +1,204 @@
+package archive
+
+import (
+	"lfm_lookout/internal/audit"
+
+	"database/sql"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Each group is kept as a single row, updated for as long as the group is
+// seen. Times are in Unix seconds, and started is 0 for groups which were
+// never seen in an adventure.
+const schema = `
+CREATE TABLE IF NOT EXISTS observations (
+	server       TEXT    NOT NULL,
+	group_id     INTEGER NOT NULL,
+	first_seen   INTEGER NOT NULL,
+	last_seen    INTEGER NOT NULL,
+	quest        TEXT    NOT NULL,
+	pack         TEXT    NOT NULL,
+	patron       TEXT    NOT NULL,
+	group_size   TEXT    NOT NULL,
+	difficulty   TEXT    NOT NULL,
+	min_level    INTEGER NOT NULL,
+	max_level    INTEGER NOT NULL,
+	peak_members INTEGER NOT NULL,
+	started      INTEGER NOT NULL,
+	comment      TEXT    NOT NULL,
+	PRIMARY KEY (server, group_id)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS observations_last_seen ON observations (last_seen);
+`
+
+const upsert = `
+INSERT INTO observations (
+	server, group_id, first_seen, last_seen, quest, pack, patron, group_size,
+	difficulty, min_level, max_level, peak_members, started, comment
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (server, group_id) DO UPDATE SET
+	last_seen = excluded.last_seen,
+	quest = excluded.quest,
+	pack = excluded.pack,
+	patron = excluded.patron,
+	group_size = excluded.group_size,
+	difficulty = excluded.difficulty,
+	min_level = excluded.min_level,
+	max_level = excluded.max_level,
+	peak_members = max(peak_members, excluded.peak_members),
+	started = CASE WHEN started = 0 THEN excluded.started ELSE started END,
+	comment = excluded.comment
+`
+
+// Archive records the groups of every audit into a SQLite database, keeping
+// them for the retention period.
+type Archive struct {
+	db        *sql.DB
+	retention time.Duration
+	lock      sync.Mutex
+	lastPrune time.Time
+}
+
+// Open opens, or creates, the archive at the path. Groups last seen longer
+// ago than the retention are pruned; a retention of 0 keeps them forever.
+func Open(path string, retention time.Duration) (*Archive, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows only one writer at a time.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Archive{db: db, retention: retention}, nil
+}
+
+// Close
+func (a *Archive) Close() error {
+	return a.db.Close()
+}
+
+// Record archives the groups of the audit, as seen at the time given, and
+// prunes expired groups once a day.
+func (a *Archive) Record(groups *audit.Audit, seen time.Time) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(upsert)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	now := seen.Unix()
+	for _, server := range groups.Servers {
+		for _, g := range server.Groups {
+			var started int64
+			if g.AdventureActive > 0 {
+				started = seen.Add(-time.Minute * time.Duration(g.AdventureActive)).Unix()
+			}
+			_, err := stmt.Exec(
+				server.Name, int64(g.Id), now, now,
+				g.Quest.Name, g.Quest.AdventurePack, g.Quest.Patron, g.Quest.GroupSize,
+				g.Difficulty, g.MinLevel, g.MaxLevel, 1+len(g.Members), started, g.Comment)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if a.retention > 0 && seen.Sub(a.lastPrune) > time.Hour*24 {
+		a.lastPrune = seen
+		return a.prune(seen.Add(-a.retention))
+	}
+	return nil
+}
+
+// prune deletes the groups last seen before the time given.
+func (a *Archive) prune(before time.Time) error {
+	_, err := a.db.Exec(`DELETE FROM observations WHERE last_seen < ?`, before.Unix())
+	return err
+}
+
+// Observation is a group as it was last archived.
+type Observation struct {
+	Server      string
+	GroupID     uint64
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	Started     time.Time // Zero for groups never seen in an adventure.
+	Quest       string
+	Pack        string
+	Patron      string
+	GroupSize   string
+	Difficulty  string
+	MinLevel    int
+	MaxLevel    int
+	PeakMembers int
+	Comment     string
+}
+
+// Group rebuilds the group as it was last seen, as far as the archive keeps
+// it, with its peak number of members.
+func (o Observation) Group() audit.Group {
+	g := audit.Group{
+		Id:      o.GroupID,
+		Comment: o.Comment,
+		Quest: audit.Quest{
+			Name:          o.Quest,
+			AdventurePack: o.Pack,
+			Patron:        o.Patron,
+			GroupSize:     o.GroupSize,
+		},
+		Difficulty: o.Difficulty,
+		MinLevel:   o.MinLevel,
+		MaxLevel:   o.MaxLevel,
+	}
+	if o.PeakMembers > 1 {
+		g.Members = make([]audit.Member, o.PeakMembers-1)
+	}
+	return g
+}
+
+// Observations retrieves the server's groups first seen since the time
+// given, oldest first.
+func (a *Archive) Observations(server string, since time.Time) ([]Observation, error) {
+	rows, err := a.db.Query(
+		`SELECT group_id, first_seen, last_seen, started, quest, pack, patron,
+		group_size, difficulty, min_level, max_level, peak_members, comment
+		FROM observations WHERE server = ? AND first_seen >= ? ORDER BY first_seen`,
+		server, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var observations []Observation
+	for rows.Next() {
+		o := Observation{Server: server}
+		var id, first, last, started int64
+		err := rows.Scan(&id, &first, &last, &started, &o.Quest, &o.Pack, &o.Patron,
+			&o.GroupSize, &o.Difficulty, &o.MinLevel, &o.MaxLevel, &o.PeakMembers, &o.Comment)
+		if err != nil {
+			return nil, err
+		}
+		o.GroupID = uint64(id)
+		o.FirstSeen = time.Unix(first, 0)
+		o.LastSeen = time.Unix(last, 0)
+		if started != 0 {
+			o.Started = time.Unix(started, 0)
+		}
+		observations = append(observations, o)
+	}
+	return observations, rows.Err()
+}
@@ -0,0 +1,114 @@
+package archive
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Count is the number of groups recorded under a name.
+type Count struct {
+	Name string
+	N    int
+}
+
+// Stats summarizes the archived groups of a server. Hours and weekdays are
+// in UTC, and are counted by when groups were first seen.
+type Stats struct {
+	Server    string
+	Groups    int
+	Since     time.Time
+	ByHour    [24]int
+	ByWeekday [7]int // Sunday first, as with time.Weekday.
+	Quests    []Count
+	Packs     []Count
+	// The average time raids took from being posted to starting, across the
+	// number of raids seen both posted and started.
+	RaidFill    time.Duration
+	RaidsFilled int
+}
+
+// Stats summarizes the server's archived groups, listing up to top of the
+// most-run quests and packs.
+func (a *Archive) Stats(server string, top int) (Stats, error) {
+	s := Stats{Server: server}
+	var since sql.NullInt64
+	err := a.db.QueryRow(
+		`SELECT count(*), min(first_seen) FROM observations WHERE server = ?`,
+		server).Scan(&s.Groups, &since)
+	if err != nil || s.Groups == 0 {
+		return s, err
+	}
+	s.Since = time.Unix(since.Int64, 0)
+
+	err = a.countInto(s.ByHour[:],
+		`SELECT CAST(strftime('%H', first_seen, 'unixepoch') AS INTEGER), count(*)
+		FROM observations WHERE server = ? GROUP BY 1`, server)
+	if err != nil {
+		return s, err
+	}
+	err = a.countInto(s.ByWeekday[:],
+		`SELECT CAST(strftime('%w', first_seen, 'unixepoch') AS INTEGER), count(*)
+		FROM observations WHERE server = ? GROUP BY 1`, server)
+	if err != nil {
+		return s, err
+	}
+	s.Quests, err = a.topCounts(
+		`SELECT quest, count(*) FROM observations WHERE server = ? AND quest != ''
+		GROUP BY quest ORDER BY 2 DESC, 1 LIMIT ?`, server, top)
+	if err != nil {
+		return s, err
+	}
+	s.Packs, err = a.topCounts(
+		`SELECT pack, count(*) FROM observations WHERE server = ? AND pack != ''
+		GROUP BY pack ORDER BY 2 DESC, 1 LIMIT ?`, server, top)
+	if err != nil {
+		return s, err
+	}
+	var fill sql.NullFloat64
+	err = a.db.QueryRow(
+		`SELECT avg(started - first_seen), count(*) FROM observations
+		WHERE server = ? AND group_size = 'Raid' AND started >= first_seen`,
+		server).Scan(&fill, &s.RaidsFilled)
+	if err != nil {
+		return s, err
+	}
+	s.RaidFill = time.Duration(fill.Float64 * float64(time.Second))
+	return s, nil
+}
+
+// countInto runs a query of index and count pairs, adding each count to
+// its index of counts.
+func (a *Archive) countInto(counts []int, query string, args ...interface{}) error {
+	rows, err := a.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i, n int
+		if err := rows.Scan(&i, &n); err != nil {
+			return err
+		}
+		if i >= 0 && i < len(counts) {
+			counts[i] += n
+		}
+	}
+	return rows.Err()
+}
+
+func (a *Archive) topCounts(query string, args ...interface{}) ([]Count, error) {
+	rows, err := a.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var counts []Count
+	for rows.Next() {
+		var c Count
+		if err := rows.Scan(&c.Name, &c.N); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
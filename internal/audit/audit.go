@@ -25,6 +25,20 @@ func Groups() (*Audit, error) {
 	return &auditObject, nil
 }
 
+// GroupsFromFile reads an audit saved from the PlayerAudit groups API, for use
+// without a connection to it.
+func GroupsFromFile(path string) (*Audit, error) {
+	var auditObject Audit
+
+	auditData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return &auditObject, err
+	}
+
+	err = json.Unmarshal(auditData, &auditObject.Servers)
+	return &auditObject, err
+}
+
 // More precisely, this is checking for a significant
 // enough change in state to warrant re-checking it against queries, as it will
 // only be used between different time-points of the same group ID.
@@ -56,14 +56,14 @@ type Quest struct {
 }
 
 type Member struct {
+	Name     string   `json:"Name"`
 	Location Location `json:"Location"`
-	// Name string `json:"Name"`
 	// Gender string `json:"Gender"`
 	// Race string `json:"Race"`
 	// TotalLevel uint8 `json:"TotalLevel"`
 	// Classes []Class `json:"Classes"`
 	// GroupId uint64 `json:"GroupId"`
-	// Guild string `json:"Guild"`
+	Guild string `json:"Guild"`
 	// InParty bool `json:"InParty"`
 	// HomeServer string `json:"HomeServer"`
 }
@@ -80,6 +80,12 @@ type Location struct {
 }
 
 func (group Group) String() string {
+	return group.Format(0)
+}
+
+// Format formats the group as String does, showing its members out of the
+// capacity when the capacity is known.
+func (group Group) Format(capacity int) string {
 	b := strings.Builder{}
 	// Line of quest info, and how long it has been active.
 	if group.Quest != (Quest{}) {
@@ -89,9 +95,13 @@ func (group Group) String() string {
 		}
 		b.WriteString("\n")
 	}
-	// Line with level range, number of members, and difficulty.
+	// Line with level range, number of members, difficulty, and id.
 	numMembers := 1 + len(group.Members)
-	fmt.Fprintf(&b, "> **%d-%d** | %d Member(s) | %s", group.MinLevel, group.MaxLevel, numMembers, group.Difficulty)
+	if capacity > 0 {
+		fmt.Fprintf(&b, "> **%d-%d** | %d/%d | %s | #%d", group.MinLevel, group.MaxLevel, numMembers, capacity, group.Difficulty, group.Id)
+	} else {
+		fmt.Fprintf(&b, "> **%d-%d** | %d Member(s) | %s | #%d", group.MinLevel, group.MaxLevel, numMembers, group.Difficulty, group.Id)
+	}
 	// Line with comment.
 	if group.Comment != "" {
 		fmt.Fprintf(&b, "\n> %s", group.Comment)
@@ -19,7 +19,7 @@ var ActiveHelp = discordgo.MessageEmbed{
 // [prefix]active
 // Retrieves the user's active Lookout queries from the query database and
 // returns the user the formatted listing in a message.
-func Active(session *discordgo.Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+func Active(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
 	queries, err := env.Repo.FindByAuthor(message.Author.ID)
 	if err != nil {
 		env.Log.Error(
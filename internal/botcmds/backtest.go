@@ -0,0 +1,188 @@
+package botcmds
+
+import (
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/matcher"
+
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+const (
+	backtestDaysDefault int = 7
+	backtestDaysMax     int = 30
+	// The number of matched groups shown as a sample.
+	backtestSample int = 5
+	// The most characters Discord allows in an embed field's value.
+	embedFieldMax int = 1024
+)
+
+var BacktestHelp = discordgo.MessageEmbed{
+	Title: "Backtest Command",
+	Description: "*[prefix]backtest Server:[string] (-/+)term (-/+)\"a phrase\" ([days])*\n\n" +
+		fmt.Sprintf("Replays a lookout query against the groups archived over the last number of days (%d by default, up to %d),",
+			backtestDaysDefault, backtestDaysMax) +
+		" and reports how often it would have notified you, when, and a sample of the groups it would have matched." +
+		" Any Duration field is ignored. As the archive keeps each group as it was last seen, the results are an estimate.\n" +
+		"Ex: `lo!backtest Server:Cannith Level:30 +Raid 14`",
+}
+
+var backtestDuration = regexp.MustCompile(`\s*Duration:\s*\S+`)
+
+// [prefix]backtest Server:[string] (-/+)term (-/+)"a phrase" ([days])
+// Matches the query against the server's archived groups, with the same
+// matcher as the ticker, and summarizes the groups it would have notified of.
+func Backtest(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	if env.Archive == nil {
+		session.ChannelMessageSend(message.ChannelID, "Backtesting isn't available, as groups aren't being archived.")
+		return
+	}
+	raw := message.Content[len("backtest"):]
+	days := backtestDaysDefault
+	if tokens := strings.Fields(raw); len(tokens) > 0 {
+		last := tokens[len(tokens)-1]
+		if n, err := strconv.Atoi(last); err == nil {
+			if n < 1 || n > backtestDaysMax {
+				session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Please backtest over 1 to %d days.", backtestDaysMax))
+				return
+			}
+			days = n
+			raw = strings.TrimSpace(raw)
+			raw = raw[:len(raw)-len(last)]
+		}
+	}
+	raw = backtestDuration.ReplaceAllString(raw, "")
+	s, packs, ok := prepareQuery(session, message, env, raw)
+	if !ok {
+		return
+	}
+	server := boardServer.FindStringSubmatch(s)[1]
+	since := time.Now().Add(-time.Hour * 24 * time.Duration(days))
+	observations, err := env.Archive.Observations(server, since)
+	if err != nil {
+		env.Log.Error(
+			"Error retrieving archived groups.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	if len(observations) == 0 {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("No groups have been recorded for %s in the last %d day(s).", server, days))
+		return
+	}
+	groups := make([]matcher.SearchableGroup, len(observations))
+	firstSeen := make(map[string]time.Time, len(observations))
+	for i, o := range observations {
+		groups[i] = matcher.NewSearchableGroup(o.Server, o.Group(), o.LastSeen)
+		firstSeen[groups[i].Key()] = o.FirstSeen
+	}
+	index, err := matcher.NewIndex(groups)
+	if err != nil {
+		env.Log.Error(
+			"Error indexing archived groups.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	defer index.Close()
+	// Archived groups are rebuilt at their peak size, so those which went on
+	// to fill would look full throughout. Unlike live matching, full groups
+	// are kept, as they were most likely open when first seen.
+	matches, err := index.Match(s, matcher.Filter{Packs: packs}, len(groups))
+	if err != nil {
+		env.Log.Error(
+			"Error matching archived groups.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("There was an error processing the query: %s", err))
+		return
+	}
+	_, err = session.ChannelMessageSendEmbed(message.ChannelID, backtestEmbed(s, days, len(groups), matches, firstSeen, env.Config.AuditPeriod))
+	if err != nil {
+		env.Log.Error(
+			"Error sending backtest.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+	}
+}
+
+// backtestEmbed summarizes the matched groups. Groups first seen in the same
+// audit would have been sent in a single notification.
+func backtestEmbed(query string, days int, total int, matches []matcher.SearchableGroup, firstSeen map[string]time.Time, auditPeriod int) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("Backtest of the last %d day(s)", days),
+		Description: strings.TrimSpace(query),
+	}
+	if len(matches) == 0 {
+		embed.Fields = []*discordgo.MessageEmbedField{
+			{Name: "Matches", Value: fmt.Sprintf("None of the %d archived group(s) matched.", total)},
+		}
+		return embed
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return firstSeen[matches[i].Key()].After(firstSeen[matches[j].Key()])
+	})
+	period := int64(auditPeriod)
+	if period < 1 {
+		period = 1
+	}
+	audits := make(map[int64]bool)
+	var byHour [24]int
+	for _, m := range matches {
+		seen := firstSeen[m.Key()]
+		audits[seen.Unix()/period] = true
+		byHour[seen.UTC().Hour()]++
+	}
+	busiest := 0
+	for h, n := range byHour {
+		if n > byHour[busiest] {
+			busiest = h
+		}
+	}
+	when := fmt.Sprintf("First: %s\nLast: %s\nBusiest: %02d:00-%02d:00 UTC (%d group(s))",
+		firstSeen[matches[len(matches)-1].Key()].UTC().Format(time.RFC1123),
+		firstSeen[matches[0].Key()].UTC().Format(time.RFC1123),
+		busiest, (busiest+1)%24, byHour[busiest])
+	// Sample as many groups as fit in the field.
+	var sample strings.Builder
+	for i, m := range matches {
+		if i == backtestSample {
+			break
+		}
+		entry := fmt.Sprintf("**%s**\n%s\n", firstSeen[m.Key()].UTC().Format("Jan 2 15:04"), m.String())
+		if sample.Len()+len(entry) > embedFieldMax {
+			if sample.Len() == 0 {
+				sample.WriteString(truncate(entry, embedFieldMax))
+			}
+			break
+		}
+		sample.WriteString(entry)
+	}
+	embed.Fields = []*discordgo.MessageEmbedField{
+		{Name: "Matches", Value: fmt.Sprintf("%d of %d archived group(s)", len(matches), total), Inline: true},
+		{Name: "Notifications", Value: fmt.Sprintf("%d, about %.1f a day", len(audits), float64(len(audits))/float64(days)), Inline: true},
+		{Name: "When", Value: when},
+		{Name: "Most recent matches", Value: sample.String()},
+	}
+	return embed
+}
+
+// truncate shortens s to at most n bytes, ending it with an ellipsis if cut.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	cut := s[:n-len("...")]
+	// Drop any rune cut in two.
+	for !utf8.ValidString(cut) {
+		cut = cut[:len(cut)-1]
+	}
+	return cut + "..."
+}
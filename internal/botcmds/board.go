@@ -0,0 +1,159 @@
+package botcmds
+
+import (
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/lodb"
+
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+var BoardHelp = discordgo.MessageEmbed{
+	Title: "Board Command",
+	Description: "*[prefix]board*\n" +
+		"*[prefix]board add [#channel] [query]*\n" +
+		"*[prefix]board remove [board id]*\n\n" +
+		"Lists the server's live boards, or adds or removes one. A board is a message" +
+		" which is kept up to date with the groups matching its query, along with counts" +
+		" per level band. Queries are written as with the lookout command, without a duration." +
+		" Requires the Manage Channels permission.\n" +
+		"Ex: `lo!board add #lfm Server:Cannith`",
+}
+
+var boardServer = regexp.MustCompile(`\+Server: (\w+)`)
+
+// [prefix]board ([add|remove] ...)
+// Retrieves the guild's boards from the query database and returns them
+// formatted, or adds or removes a board.
+func Board(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	strTokens := strings.Fields(message.Content)
+	if len(strTokens) == 1 {
+		listBoards(session, message, env)
+		return
+	}
+	switch strings.ToLower(strTokens[1]) {
+	case "add":
+		addBoard(session, message, env, strTokens)
+	case "remove":
+		removeBoard(session, message, env, strTokens)
+	default:
+		session.ChannelMessageSend(message.ChannelID, "Please specify whether to add or remove a board.")
+	}
+}
+
+func listBoards(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	boards, err := env.Repo.FindBoardsByGuild(message.GuildID)
+	if err != nil {
+		env.Log.Error(
+			"Error retrieving guild's boards.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	if len(boards) == 0 {
+		session.ChannelMessageSend(message.ChannelID, "No boards found.")
+		return
+	}
+	fields := make([]*discordgo.MessageEmbedField, len(boards))
+	for i, b := range boards {
+		fields[i] = &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("ID: %d", b.ID),
+			Value: fmt.Sprintf("%s\n*Posted in:* <#%s>", b.Query, b.ChannelID),
+		}
+	}
+	embed := &discordgo.MessageEmbed{
+		Title:  "Boards",
+		Fields: fields,
+	}
+	session.ChannelMessageSendEmbed(message.ChannelID, embed)
+}
+
+func addBoard(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv, strTokens []string) {
+	if len(strTokens) < 3 {
+		session.ChannelMessageSend(message.ChannelID, "Please specify a channel for the board.")
+		return
+	}
+	channelID, ok := targetChannel(session, message, env, "board add", strTokens[2], discordgo.PermissionManageChannels)
+	if !ok {
+		return
+	}
+	raw := afterTokens(message.Content, 3)
+	if strings.Contains(raw, "Duration:") {
+		session.ChannelMessageSend(message.ChannelID, "Boards last until they are removed, and don't take a duration.")
+		return
+	}
+	s, packs, ok := prepareQuery(session, message, env, raw)
+	if !ok {
+		return
+	}
+	b := lodb.Board{
+		GuildID:   message.GuildID,
+		ChannelID: channelID,
+		CreatorID: message.Author.ID,
+		Server:    boardServer.FindStringSubmatch(s)[1],
+		Query:     s,
+		Packs:     packs,
+	}
+	// Post the board's first message, to be filled in on the next tick.
+	m, err := session.ChannelMessageSendEmbed(b.ChannelID, &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("%s LFM Board", b.Server),
+		Description: "The board will be filled in shortly.",
+	})
+	if err != nil {
+		env.Log.Warn(
+			"Error posting board.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Unable to post the board in that channel.")
+		return
+	}
+	b.MessageIDs = []string{m.ID}
+	id, errS := env.Repo.SaveBoard(b)
+	if errS == lodb.ErrGuildBoardsFull {
+		session.ChannelMessageDelete(b.ChannelID, m.ID)
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("This server already has %d boards.", lodb.BOARDMAX))
+	} else if errS != nil {
+		env.Log.Error(
+			"Error saving board.",
+			zap.Error(errS))
+		session.ChannelMessageDelete(b.ChannelID, m.ID)
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+	} else {
+		env.Log.Info(
+			"New Board",
+			zap.String("guild", b.GuildID),
+			zap.String("query", s))
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Board %d saved.", id))
+	}
+}
+
+func removeBoard(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv, strTokens []string) {
+	if len(strTokens) < 3 {
+		session.ChannelMessageSend(message.ChannelID, "Please specify the ID of the board to remove.")
+		return
+	}
+	id, err := strconv.Atoi(strTokens[2])
+	if err != nil {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("%s is not a board ID.", strTokens[2]))
+		return
+	}
+	b, err := env.Repo.DeleteBoard(message.GuildID, id)
+	if err == lodb.ErrBoardNotFound {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("No board with the ID %d was found.", id))
+		return
+	} else if err != nil {
+		env.Log.Error(
+			"Error deleting board.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	for _, m := range b.MessageIDs {
+		session.ChannelMessageDelete(b.ChannelID, m)
+	}
+	session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Board %d was removed.", id))
+}
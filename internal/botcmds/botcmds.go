@@ -6,22 +6,52 @@ import (
 	"github.com/bwmarrin/discordgo"
 )
 
+// Session is the part of a Discord session which commands reply through, so
+// that frontends other than Discord can run them.
+type Session interface {
+	ChannelMessageSend(channelID string, content string) (*discordgo.Message, error)
+	ChannelMessageSendEmbed(channelID string, embed *discordgo.MessageEmbed) (*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error)
+	ChannelMessageEditEmbed(channelID, messageID string, embed *discordgo.MessageEmbed) (*discordgo.Message, error)
+	ChannelMessageDelete(channelID, messageID string) error
+	UserChannelPermissions(userID, channelID string) (int, error)
+	Channel(channelID string) (*discordgo.Channel, error)
+}
+
+// Handler runs a command in response to a message. The message's content has
+// had the prefix removed, and starts with the command's name.
+type Handler func(Session, *discordgo.MessageCreate, *botenv.BotEnv)
+
 type Command struct {
-	Cmd     func(*discordgo.Session, *discordgo.MessageCreate, *botenv.BotEnv)
+	Cmd     Handler
 	HelpMsg discordgo.MessageEmbed
+	// Permission holds the Discord permission bits a member needs in the
+	// channel to run the command, if any.
+	Permission int
+	// Set for commands only the bot's owner can run.
+	OwnerOnly bool
 }
 
 var Commands = map[string]Command{
-	"active":  Command{Cmd: Active, HelpMsg: ActiveHelp},
-	"cancel":  Command{Cmd: Cancel, HelpMsg: CancelHelp},
-	"groups":  Command{Cmd: Groups, HelpMsg: GroupsHelp},
-	"lookout": Command{Cmd: Lookout, HelpMsg: LookoutHelp},
-	"servers": Command{Cmd: Servers, HelpMsg: ServersHelp},
+	"active":   Command{Cmd: Active, HelpMsg: ActiveHelp},
+	"backtest": Command{Cmd: Backtest, HelpMsg: BacktestHelp},
+	"board":    Command{Cmd: Board, HelpMsg: BoardHelp, Permission: discordgo.PermissionManageChannels},
+	"cancel":   Command{Cmd: Cancel, HelpMsg: CancelHelp},
+	"char":     Command{Cmd: Char, HelpMsg: CharHelp},
+	"config":   Command{Cmd: Config, HelpMsg: ConfigHelp, Permission: discordgo.PermissionManageServer},
+	"friends":  Command{Cmd: Friends, HelpMsg: FriendsHelp},
+	"groups":   Command{Cmd: Groups, HelpMsg: GroupsHelp},
+	"lookout":  Command{Cmd: Lookout, HelpMsg: LookoutHelp},
+	"servers":  Command{Cmd: Servers, HelpMsg: ServersHelp},
+	"stats":    Command{Cmd: Stats, HelpMsg: StatsHelp},
+	"storage":  Command{Cmd: Storage, HelpMsg: StorageHelp, OwnerOnly: true},
+	"watch":    Command{Cmd: Watch, HelpMsg: WatchHelp},
+	"watches":  Command{Cmd: Watches, HelpMsg: WatchesHelp},
 }
 
 var CommandsMsg = discordgo.MessageEmbed{
 	Title: "Commands Help",
-	Description: "active\ncancel\ngroups\nlookout\nservers\n\n" +
+	Description: "active\nbacktest\nboard\ncancel\nchar\nconfig\nfriends\ngroups\nlookout\nservers\nstats\nstorage\nwatch\nwatches\n\n" +
 		"For more information on a command, use `lo!help [command]`\n" +
 		"Ex: `lo!help groups`",
 }
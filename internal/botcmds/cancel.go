@@ -22,7 +22,7 @@ var CancelHelp = discordgo.MessageEmbed{
 // [prefix]cancel [query id]
 // Removes the specified Lookout query for the query database if it exists and
 // belongs to the user.
-func Cancel(session *discordgo.Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+func Cancel(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
 	// Parse out the index rune.
 	f := (strings.Fields(message.Content))
 	id := f[len(f)-1]
@@ -32,7 +32,7 @@ func Cancel(session *discordgo.Session, message *discordgo.MessageCreate, env *b
 	}
 	r := rune(i)
 	// Check the rune.
-	if r < lodb.IDMIN || r > lodb.IDMAX*lodb.TICKPERIOD {
+	if id := lodb.QueryID(r); id < lodb.IDMIN || id > lodb.IDMAX {
 		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("The ID %s is not within an acceptable range.", string(r)))
 		return
 	}
@@ -0,0 +1,292 @@
+package botcmds
+
+import (
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/lodb"
+
+	"crypto/rand"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+const (
+	charLevelMax int = 34
+)
+
+var CharHelp = discordgo.MessageEmbed{
+	Title: "Char Command",
+	Description: "*[prefix]char*\n" +
+		"*[prefix]char [name]*\n" +
+		"*[prefix]char set [name] Server:[string] Level:[1-34] (Classes:[class,class]) (Packs:\"[pack, pack]\") (VIP:[yes|no])*\n" +
+		"*[prefix]char verify [name]*\n" +
+		"*[prefix]char remove [name]*\n\n" +
+		"Lists your characters, shows one, or registers, updates, verifies or removes one." +
+		fmt.Sprintf(" You can register up to %d characters.", lodb.CHARMAX) +
+		" A query with *char:[name]* is filled in with the character's server and level," +
+		" and, for characters which aren't VIP and have their packs listed, leaves out groups for packs they don't have." +
+		" To verify a character, lead a group with it with the code *verify* gives in the group's comment, and run *verify* again while the group is listed.\n" +
+		"Ex: `lo!char set MyToon Server:Cannith Level:30 Classes:Wizard Packs:\"Menace of the Underdark\"`\n" +
+		"Ex: `lo!lookout char:MyToon Duration:2h +raid`",
+}
+
+var (
+	charField  = regexp.MustCompile(`(?i)\b(server|level|classes|packs|vip):\s*(?:"([^"]*)"|(\S+))`)
+	charLookup = regexp.MustCompile(`(?i)\+?\bchar:\s*(\S+)`)
+)
+
+// [prefix]char ([name]|set [name] ...|verify [name]|remove [name])
+// Retrieves the user's characters and returns them formatted, or registers,
+// updates, verifies or removes a character.
+func Char(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	strTokens := strings.Fields(message.Content)
+	var sub string
+	if len(strTokens) > 1 {
+		sub = strings.ToLower(strTokens[1])
+	}
+	switch {
+	case len(strTokens) == 1:
+		listCharacters(session, message, env)
+	case (sub == "set" || sub == "verify" || sub == "remove") && len(strTokens) == 2:
+		session.ChannelMessageSend(message.ChannelID, "Please specify a character.")
+	case len(strTokens) == 2:
+		c, err := env.Repo.FindCharacter(message.Author.ID, strTokens[1])
+		if err == lodb.ErrCharacterNotFound {
+			session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("You have no character named %s.", strTokens[1]))
+			return
+		} else if err != nil {
+			env.Log.Error(
+				"Error retrieving character.",
+				zap.Error(err))
+			session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+			return
+		}
+		session.ChannelMessageSendEmbed(message.ChannelID, &discordgo.MessageEmbed{
+			Title:  "Character",
+			Fields: []*discordgo.MessageEmbedField{characterField(c)},
+		})
+	case sub == "set":
+		setCharacter(session, message, env, strTokens[2], afterTokens(message.Content, 3))
+	case sub == "verify":
+		verifyCharacter(session, message, env, strTokens[2])
+	case sub == "remove":
+		switch err := env.Repo.DeleteCharacter(message.Author.ID, strTokens[2]); err {
+		case nil:
+			session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Removed %s.", strTokens[2]))
+		case lodb.ErrCharacterNotFound:
+			session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("You have no character named %s.", strTokens[2]))
+		default:
+			env.Log.Error(
+				"Error deleting character.",
+				zap.Error(err))
+			session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		}
+	default:
+		session.ChannelMessageSend(message.ChannelID, "Please specify whether to set, verify or remove a character.")
+	}
+}
+
+func listCharacters(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	chars, err := env.Repo.FindCharactersByUser(message.Author.ID)
+	if err != nil {
+		env.Log.Error(
+			"Error retrieving characters.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	if len(chars) == 0 {
+		session.ChannelMessageSend(message.ChannelID, "You have no characters registered.")
+		return
+	}
+	fields := make([]*discordgo.MessageEmbedField, len(chars))
+	for i, c := range chars {
+		fields[i] = characterField(c)
+	}
+	session.ChannelMessageSendEmbed(message.ChannelID, &discordgo.MessageEmbed{
+		Title:  "Characters",
+		Fields: fields,
+	})
+}
+
+func characterField(c lodb.Character) *discordgo.MessageEmbedField {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s | Level %d", c.Server, c.Level)
+	if len(c.Classes) > 0 {
+		fmt.Fprintf(&b, " | %s", strings.Join(c.Classes, "/"))
+	}
+	if c.Verified {
+		b.WriteString(" | Verified")
+	}
+	switch {
+	case c.VIP:
+		b.WriteString("\n*Packs:* VIP")
+	case len(c.Packs) > 0:
+		fmt.Fprintf(&b, "\n*Packs:* %s", strings.Join(c.Packs, ", "))
+	}
+	return &discordgo.MessageEmbedField{Name: c.Name, Value: b.String()}
+}
+
+func setCharacter(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv, name string, fields string) {
+	c, err := env.Repo.FindCharacter(message.Author.ID, name)
+	if err == lodb.ErrCharacterNotFound {
+		c = lodb.Character{UserID: message.Author.ID, Name: name}
+	} else if err != nil {
+		env.Log.Error(
+			"Error retrieving character.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	server := c.Server
+	for _, m := range charField.FindAllStringSubmatch(fields, -1) {
+		value := m[2] + m[3]
+		switch strings.ToLower(m[1]) {
+		case "server":
+			resolved, suggestions := resolveServer(env, value)
+			if resolved == "" {
+				session.ChannelMessageSend(message.ChannelID, didYouMean("server", value, suggestions))
+				return
+			}
+			c.Server = resolved
+		case "level":
+			level, err := strconv.Atoi(value)
+			if err != nil || level < 1 || level > charLevelMax {
+				session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("The level must be from 1 to %d.", charLevelMax))
+				return
+			}
+			c.Level = level
+		case "classes":
+			c.Classes = splitList(value)
+		case "packs":
+			c.Packs = splitList(value)
+		case "vip":
+			c.VIP = strings.EqualFold(value, "yes") || strings.EqualFold(value, "true")
+		}
+	}
+	if c.Server == "" || c.Level == 0 {
+		session.ChannelMessageSend(message.ChannelID, "Please specify the character's server and level.")
+		return
+	}
+	if c.Server != server {
+		// A character on another server is another character.
+		c.Verified, c.Code = false, ""
+	}
+	switch err := env.Repo.SaveCharacter(c); err {
+	case nil:
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Saved %s.", c.Name))
+	case lodb.ErrUserCharactersFull:
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("You can only register up to %d characters.", lodb.CHARMAX))
+	default:
+		env.Log.Error(
+			"Error saving character.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+	}
+}
+
+// verifyCharacter verifies the character is the user's, once a group led by
+// it is listed with the character's code in its comment. The code is made,
+// and given to the user, the first time.
+func verifyCharacter(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv, name string) {
+	c, err := env.Repo.FindCharacter(message.Author.ID, name)
+	if err == lodb.ErrCharacterNotFound {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("You have no character named %s.", name))
+		return
+	} else if err != nil {
+		env.Log.Error(
+			"Error retrieving character.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	if c.Verified {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("%s is already verified.", c.Name))
+		return
+	}
+	reply := fmt.Sprintf("%s is verified.", c.Name)
+	if c.Code == "" {
+		code := make([]byte, 4)
+		if _, err := rand.Read(code); err != nil {
+			env.Log.Error(
+				"Error making verification code.",
+				zap.Error(err))
+			session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+			return
+		}
+		c.Code = fmt.Sprintf("LO-%X", code)
+	} else {
+		c.Verified = isLeadingWith(env, c, c.Code)
+	}
+	if !c.Verified {
+		reply = fmt.Sprintf("To verify %s, lead a group with it on %s with `%s` in the group's comment,"+
+			" then run this again while the group is listed.", c.Name, c.Server, c.Code)
+	}
+	if err := env.Repo.SaveCharacter(c); err != nil {
+		env.Log.Error(
+			"Error saving character.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	session.ChannelMessageSend(message.ChannelID, reply)
+}
+
+// isLeadingWith reports whether the character leads a current group whose
+// comment has the code in it.
+func isLeadingWith(env *botenv.BotEnv, c lodb.Character, code string) bool {
+	for _, sGroup := range env.Snapshot().Audit.Map[c.Server] {
+		if strings.EqualFold(sGroup.Group.Leader.Name, c.Name) &&
+			strings.Contains(strings.ToUpper(sGroup.Group.Comment), code) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// fillCharacter replaces a char field of the query with the character's
+// server and level, where the query doesn't give them, and gives the packs the
+// character has, unless it's VIP or they aren't listed. Any problem is
+// returned as a message for the user.
+func fillCharacter(env *botenv.BotEnv, userID string, query string) (string, []string, string) {
+	m := charLookup.FindStringSubmatch(query)
+	if m == nil {
+		return query, nil, ""
+	}
+	c, err := env.Repo.FindCharacter(userID, m[1])
+	if err == lodb.ErrCharacterNotFound {
+		return query, nil, fmt.Sprintf("You have no character named %s.", m[1])
+	} else if err != nil {
+		env.Log.Error(
+			"Error retrieving character.",
+			zap.Error(err))
+		return query, nil, "Oh dear, it seems like there was a problem."
+	}
+	var fill []string
+	if !regexp.MustCompile(`\bServer:`).MatchString(query) {
+		fill = append(fill, "Server:"+c.Server)
+	}
+	if !regexp.MustCompile(`\bLevel:`).MatchString(query) {
+		fill = append(fill, fmt.Sprintf("Level:%d", c.Level))
+	}
+	var packs []string
+	if !c.VIP && len(c.Packs) > 0 {
+		packs = c.Packs
+	}
+	return strings.Replace(query, m[0], strings.Join(fill, " "), 1), packs, ""
+}
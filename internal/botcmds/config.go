@@ -0,0 +1,185 @@
+package botcmds
+
+import (
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/lodb"
+
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+const (
+	prefixLenMax int = 5
+)
+
+var ConfigHelp = discordgo.MessageEmbed{
+	Title: "Config Command",
+	Description: "*[prefix]config ([setting] [value])*\n\n" +
+		"Shows the bot's settings for this server, or changes one of them." +
+		" Requires the Manage Server permission.\n" +
+		" Settings: *prefix [prefix], server [server|none], style [text|embed]," +
+		" channels [add|remove|clear] (#channel...)*\n" +
+		"Ex: `lo!config server Cannith`",
+}
+
+var channelMention = regexp.MustCompile(`^<#(\d+)>$`)
+
+// [prefix]config ([setting] [value])
+// Retrieves the guild's settings and either returns them formatted, or
+// changes the specified setting and saves them again.
+func Config(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	settings, err := env.Repo.FindGuildSettings(message.GuildID)
+	if err != nil {
+		env.Log.Error(
+			"Error retrieving guild settings.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	strTokens := strings.Fields(message.Content)
+	if len(strTokens) == 1 {
+		session.ChannelMessageSendEmbed(message.ChannelID, settingsEmbed(settings, env))
+		return
+	}
+	setting := strings.ToLower(strTokens[1])
+	if len(strTokens) < 3 && setting != "channels" {
+		session.ChannelMessageSend(message.ChannelID, "Please specify a value for the setting.")
+		return
+	}
+	switch setting {
+	case "prefix":
+		if len(strTokens[2]) > prefixLenMax {
+			session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Please keep the prefix to %d characters or fewer.", prefixLenMax))
+			return
+		}
+		settings.Prefix = strTokens[2]
+	case "server":
+		if strings.ToLower(strTokens[2]) == "none" {
+			settings.DefaultServer = ""
+			break
+		}
+		server, suggestions := resolveServer(env, strTokens[2])
+		if server == "" {
+			session.ChannelMessageSend(message.ChannelID, didYouMean("server", strTokens[2], suggestions))
+			return
+		}
+		settings.DefaultServer = server
+	case "style":
+		style := strings.ToLower(strTokens[2])
+		if style != lodb.StyleText && style != lodb.StyleEmbed {
+			session.ChannelMessageSend(message.ChannelID, "The style must be either text or embed.")
+			return
+		}
+		settings.Style = style
+	case "channels":
+		if len(strTokens) < 3 {
+			session.ChannelMessageSend(message.ChannelID, "Please specify whether to add, remove, or clear channels.")
+			return
+		}
+		// Without any channels given, use the current one.
+		channels := []string{message.ChannelID}
+		if len(strTokens) > 3 {
+			channels = channels[:0]
+			for _, t := range strTokens[3:] {
+				m := channelMention.FindStringSubmatch(t)
+				if m == nil {
+					session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("%s is not a channel.", t))
+					return
+				}
+				channels = append(channels, m[1])
+			}
+		}
+		switch strings.ToLower(strTokens[2]) {
+		case "add":
+			for _, c := range channels {
+				if !containsString(settings.Channels, c) {
+					settings.Channels = append(settings.Channels, c)
+				}
+			}
+		case "remove":
+			kept := settings.Channels[:0]
+			for _, c := range settings.Channels {
+				if !containsString(channels, c) {
+					kept = append(kept, c)
+				}
+			}
+			settings.Channels = kept
+		case "clear":
+			settings.Channels = nil
+		default:
+			session.ChannelMessageSend(message.ChannelID, "Please specify whether to add, remove, or clear channels.")
+			return
+		}
+	default:
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("There is no setting named %s.", strTokens[1]))
+		return
+	}
+	if err := env.Repo.SaveGuildSettings(settings); err != nil {
+		env.Log.Error(
+			"Error saving guild settings.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	session.ChannelMessageSendEmbed(message.ChannelID, settingsEmbed(settings, env))
+}
+
+func settingsEmbed(settings lodb.GuildSettings, env *botenv.BotEnv) *discordgo.MessageEmbed {
+	prefix := settings.Prefix
+	if prefix == "" {
+		prefix = env.Config.Prefix
+	}
+	server := settings.DefaultServer
+	if server == "" {
+		server = "None"
+	}
+	style := settings.Style
+	if style == "" {
+		style = lodb.StyleText
+	}
+	channels := "All"
+	if len(settings.Channels) > 0 {
+		mentions := make([]string, len(settings.Channels))
+		for i, c := range settings.Channels {
+			mentions[i] = fmt.Sprintf("<#%s>", c)
+		}
+		channels = strings.Join(mentions, " ")
+	}
+	return &discordgo.MessageEmbed{
+		Title: "Server Settings",
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Prefix", Value: prefix, Inline: true},
+			{Name: "Default Server", Value: server, Inline: true},
+			{Name: "Notification Style", Value: style, Inline: true},
+			{Name: "Channels", Value: channels},
+		},
+	}
+}
+
+// defaultServer returns the default server of the message's guild, if it
+// has one.
+func defaultServer(message *discordgo.MessageCreate, env *botenv.BotEnv) string {
+	if message.GuildID == "" {
+		return ""
+	}
+	settings, err := env.Repo.FindGuildSettings(message.GuildID)
+	if err != nil {
+		env.Log.Error(
+			"Error retrieving guild settings.",
+			zap.Error(err))
+	}
+	return settings.DefaultServer
+}
+
+func containsString(s []string, v string) bool {
+	for i := range s {
+		if s[i] == v {
+			return true
+		}
+	}
+	return false
+}
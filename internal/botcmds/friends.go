@@ -0,0 +1,224 @@
+package botcmds
+
+import (
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/lodb"
+
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+var FriendsHelp = discordgo.MessageEmbed{
+	Title: "Friends Command",
+	Description: "*[prefix]friends*\n" +
+		"*[prefix]friends [add|remove] [player]*\n" +
+		"*[prefix]friends [add|remove] guild [guild name]*\n" +
+		"*[prefix]friends [optout|optin] [character]*\n\n" +
+		"Lists your watchlist, or adds or removes a player or guild from it." +
+		fmt.Sprintf(" You can watch up to %d players and guilds,", lodb.FRIENDMAX) +
+		" and are notified, in the channel you last changed your watchlist from," +
+		" whenever one of them leads or joins a group.\n" +
+		"Players can opt their characters out of being watched with *optout*, and back in with *optin*," +
+		" once they've registered and verified them with *char*.\n" +
+		"Ex: `lo!friends add guild Knights of the Silver Flame`",
+}
+
+// [prefix]friends ([add|remove|optout|optin] ...)
+// Retrieves the user's watchlist and returns it formatted, changes it, or
+// opts a character out of, or back into, being watched.
+func Friends(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	strTokens := strings.Fields(message.Content)
+	if len(strTokens) == 1 {
+		listFriends(session, message, env)
+		return
+	}
+	sub := strings.ToLower(strTokens[1])
+	if len(strTokens) < 3 {
+		session.ChannelMessageSend(message.ChannelID, "Please specify a player or guild.")
+		return
+	}
+	switch sub {
+	case "add", "remove":
+		guild := strings.ToLower(strTokens[2]) == "guild"
+		name := strings.TrimSpace(afterTokens(message.Content, 2))
+		if guild {
+			name = strings.TrimSpace(afterTokens(message.Content, 3))
+		}
+		if name == "" {
+			session.ChannelMessageSend(message.ChannelID, "Please specify a player or guild.")
+			return
+		}
+		changeFriends(session, message, env, sub == "add", guild, name)
+	case "optout", "optin":
+		optOut(session, message, env, sub == "optout", strTokens[2])
+	default:
+		session.ChannelMessageSend(message.ChannelID, "Please specify whether to add or remove a player or guild, or to opt out or in.")
+	}
+}
+
+func listFriends(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	f, err := env.Repo.FindFriends(message.Author.ID)
+	if err != nil {
+		env.Log.Error(
+			"Error retrieving watchlist.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	if len(f.Players)+len(f.Guilds) == 0 {
+		session.ChannelMessageSend(message.ChannelID, "Your watchlist is empty.")
+		return
+	}
+	optOuts, err := env.Repo.FindOptOuts()
+	if err != nil {
+		env.Log.Error(
+			"Error retrieving opt-outs.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	// Players who opted out after being added aren't shown.
+	var players []string
+	for _, p := range f.Players {
+		if !optOuts.HasAny(p) {
+			players = append(players, p)
+		}
+	}
+	description := fmt.Sprintf("Notifying in <#%s>.", f.ChannelID)
+	if hidden := len(f.Players) - len(players); hidden > 0 {
+		description += fmt.Sprintf(" %d player(s) who have since opted out of being watched are hidden.", hidden)
+	}
+	embed := &discordgo.MessageEmbed{
+		Title:       "Watchlist",
+		Description: description,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Players", Value: listValue(players), Inline: true},
+			{Name: "Guilds", Value: listValue(f.Guilds), Inline: true},
+		},
+	}
+	session.ChannelMessageSendEmbed(message.ChannelID, embed)
+}
+
+func changeFriends(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv, add bool, guild bool, name string) {
+	f, err := env.Repo.FindFriends(message.Author.ID)
+	if err != nil {
+		env.Log.Error(
+			"Error retrieving watchlist.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	list := &f.Players
+	if guild {
+		list = &f.Guilds
+	}
+	i := indexFold(*list, name)
+	if add {
+		if i != -1 {
+			session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("%s is already on your watchlist.", name))
+			return
+		}
+		if !guild {
+			optOuts, err := env.Repo.FindOptOuts()
+			if err != nil {
+				env.Log.Error(
+					"Error retrieving opt-outs.",
+					zap.Error(err))
+				session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+				return
+			}
+			if optOuts.HasAny(name) {
+				session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("%s has opted out of being watched.", name))
+				return
+			}
+		}
+		*list = append(*list, name)
+	} else {
+		if i == -1 {
+			session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("%s isn't on your watchlist.", name))
+			return
+		}
+		*list = append((*list)[:i], (*list)[i+1:]...)
+	}
+	f.ChannelID = message.ChannelID
+	if message.GuildID != "" {
+		settings, err := env.Repo.FindGuildSettings(message.GuildID)
+		if err != nil {
+			env.Log.Error(
+				"Error retrieving guild settings.",
+				zap.Error(err))
+		}
+		f.Style = settings.Style
+	} else {
+		f.Style = ""
+	}
+	switch err := env.Repo.SaveFriends(f); err {
+	case nil:
+		if add {
+			session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Added %s to your watchlist.", name))
+		} else {
+			session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Removed %s from your watchlist.", name))
+		}
+	case lodb.ErrFriendsFull:
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("You can only watch up to %d players and guilds.", lodb.FRIENDMAX))
+	default:
+		env.Log.Error(
+			"Error saving watchlist.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+	}
+}
+
+func optOut(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv, out bool, name string) {
+	c, err := env.Repo.FindCharacter(message.Author.ID, name)
+	if err == lodb.ErrCharacterNotFound {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Please register and verify %s with the char command first.", name))
+		return
+	} else if err != nil {
+		env.Log.Error(
+			"Error retrieving character.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	if !c.Verified {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Please verify %s with the char command first.", c.Name))
+		return
+	}
+	if out {
+		err = env.Repo.SaveOptOut(lodb.OptOut{Server: c.Server, Name: c.Name, UserID: message.Author.ID})
+	} else {
+		err = env.Repo.DeleteOptOut(c.Server, c.Name)
+	}
+	if err != nil {
+		env.Log.Error(
+			"Error changing opt-out.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	if out {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("%s has been opted out of being watched.", c.Name))
+	} else {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("%s can be watched again.", c.Name))
+	}
+}
+
+func listValue(names []string) string {
+	if len(names) == 0 {
+		return "None"
+	}
+	return strings.Join(names, "\n")
+}
+
+func indexFold(s []string, v string) int {
+	for i := range s {
+		if strings.EqualFold(s[i], v) {
+			return i
+		}
+	}
+	return -1
+}
@@ -3,8 +3,10 @@ package botcmds
 import (
 	"lfm_lookout/internal/botenv"
 
+	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
@@ -12,24 +14,34 @@ import (
 var GroupsHelp = discordgo.MessageEmbed{
 	Title: "Groups Command",
 	Description: "*[prefix]groups [server]*\n\n" +
-		"Returns a list of current groups in the specified server.\n" +
+		"Returns a list of current groups in the specified server, or the" +
+		" server's default server if none is specified.\n" +
 		"Ex: `lo!groups Cannith`",
 }
 
 // [prefix]groups [server]
 // Retrieves the server entry in audit for the specified server, if the entry
 // exists. Formats entry and then sends it to the requesting user.
-func Groups(session *discordgo.Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
-	strTokens := strings.Fields(message.Content[len(env.Config.Prefix):])
-	if len(strTokens) <= 1 {
+func Groups(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	strTokens := strings.Fields(message.Content)
+	var server string
+	if len(strTokens) > 1 {
+		server = strTokens[1]
+	} else {
+		server = defaultServer(message, env)
+	}
+	if server == "" {
 		session.ChannelMessageSend(message.ChannelID, "No server argument found.")
 		return
 	}
-	server := strings.Title(strings.ToLower(strings.TrimSpace(strTokens[1])))
+	name := strings.TrimSpace(server)
+	server, suggestions := resolveServer(env, name)
+	if server == "" {
+		session.ChannelMessageSend(message.ChannelID, didYouMean("server", name, suggestions))
+		return
+	}
 	// Search for a matching server.
-	env.AuditLock.RLock()
-	defer env.AuditLock.RUnlock()
-	serverMatch, exists := env.Audit.Map[server]
+	serverMatch, exists := env.Snapshot().Audit.Map[server]
 	if !exists {
 		session.ChannelMessageSend(message.ChannelID, "A server with that name was not found.")
 		return
@@ -46,8 +58,11 @@ func Groups(session *discordgo.Session, message *discordgo.MessageCreate, env *b
 	})
 	// With server index found, construct a formatted strings of the groups.
 	var b strings.Builder
+	if note := botenv.StaleNote(env.Stale(time.Now())); note != "" {
+		fmt.Fprintf(&b, "%s\n\n", note)
+	}
 	for i := range keys {
-		b.WriteString(serverMatch[keys[i]].Group.String())
+		b.WriteString(serverMatch[keys[i]].String())
 		b.WriteString("\n\n")
 	}
 	embed := discordgo.MessageEmbed{Title: server, Description: b.String()}
@@ -0,0 +1,24 @@
+package botcmds
+
+import (
+	"lfm_lookout/internal/botenv"
+
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// [prefix]help ([command])
+// Returns the list of commands, or the help message of the specified command.
+func Help(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	strTokens := strings.Fields(message.Content)
+	switch len(strTokens) {
+	case 1:
+		session.ChannelMessageSendEmbed(message.ChannelID, &CommandsMsg)
+	case 2:
+		command, ok := Commands[strings.ToLower(strTokens[1])]
+		if ok {
+			session.ChannelMessageSendEmbed(message.ChannelID, &command.HelpMsg)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"lfm_lookout/internal/botenv"
 	"lfm_lookout/internal/lodb"
+	"lfm_lookout/internal/matcher"
 	// "log"
 	"regexp"
 	"strconv"
@@ -23,11 +24,16 @@ var LookoutHelp = discordgo.MessageEmbed{
 	Title: "Lookout Command",
 	Description: "[prefix]lookout Server:[string] Duration:[0h1m-24h0m] (Level:[1-30]) (-/+)term (-/+)\"a phrase\"\n\n" +
 		"Saves the query so that for the specified duration, the user will be notified of any matching groups." +
+		" The Server field can be left out in a server with a default server configured." +
 		" Along with terms and phrases searched against all of a group's text, additional fields can be specified—" +
 		"similarly to the *Server* and *Duration* fields—with the field name directly followed by a colon.\n" +
 		" Valid fields: *Group.Comment, Group.Difficulty, Group.AdventureActive," +
 		" Group.Quest.Name, Group.Quest.RequiredAdventurePack, Group.Quest.AdventureArea," +
 		" Group.Quest.QuestJournalGroup, Group.Quest.GroupSize, Group.Quest.Patron*\n" +
+		" Filled in from a catalog of quests, even for groups which only name their quest in the comment:" +
+		" *Quest, QuestLevel, Pack, Patron, Tier* (heroic, epic or legendary), and *Raid* (yes or no).\n" +
+		" Also *Slots*, the number of open slots, and *Full* (yes or no). Full groups are never notified.\n" +
+		" A registered character can stand in for the Server and Level fields with *char:[name]*; see `lo!help char`.\n" +
 		"Ex: `lo!lookout Server:Cannith Duration:5h Level:30 +Raid +\"Killing Time\"`\n",
 }
 
@@ -40,44 +46,17 @@ var LookoutHelp = discordgo.MessageEmbed{
 // the field, of which they will be searched against. These can be specifed by
 // the field name, a colon, and then the search term of phrase. Optional search
 // fields include Comment, Quest, Difficulty, and Patron.
-func Lookout(session *discordgo.Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+func Lookout(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
 	errMessage := "There was an error processing the query: %s"
-	// Check that the query isn't too large.
-	if len(message.Content) > queryLenMax {
-		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Please keep queries below %d characters.", queryLenMax))
-		return
-	}
-	// Check for a "Fresh:" field which may interfere.
-	if strings.Contains(message.Content, "Fresh:") {
-		session.ChannelMessageSend(message.ChannelID, "Query cannot contain the field \"Fresh\".")
-		return
-	}
-	// Check for regex-delimitting forward-slashes.
-	if strings.ContainsRune(message.Content, '/') {
-		session.ChannelMessageSend(message.ChannelID, "Query cannoth contain the forward-slash character (\"/\").")
+	s, packs, ok := prepareQuery(session, message, env, message.Content[len("lookout"):])
+	if !ok {
 		return
 	}
-	s, err := translateQuery(message.Content[len(env.Config.Prefix)+len("lookout"):])
+	settings, err := env.Repo.FindGuildSettings(message.GuildID)
 	if err != nil {
-		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf(errMessage, err.Error()))
-		return
-	}
-	// Check that the server is specified, and matches an existing server.
-	re := regexp.MustCompile(`\+?Server:\s*(\w+)`)
-	sMatches := re.FindStringSubmatch(s)
-	if sMatches != nil {
-		sMatch := sMatches[1]
-		env.AuditLock.RLock()
-		_, exists := env.Audit.Map[sMatch]
-		env.AuditLock.RUnlock()
-		if !exists {
-			session.ChannelMessageSend(message.ChannelID, "The requested query does not seem to specify an existing server.")
-			return
-		}
-		s = re.ReplaceAllString(s, "+Server: $1")
-	} else {
-		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf(errMessage, "Missing a server field."))
-		return
+		env.Log.Error(
+			"Error retrieving guild settings.",
+			zap.Error(err))
 	}
 	// Verify and parse out the duration field.
 	durRegex := regexp.MustCompile(`\s+Duration:\s*(\S+)`)
@@ -108,12 +87,12 @@ func Lookout(session *discordgo.Session, message *discordgo.MessageCreate, env *
 		ChannelID: message.ChannelID,
 		TTL:       dur,
 		Query:     s,
+		Packs:     packs,
+		Style:     settings.Style,
+		Created:   time.Now(),
 	}
 	// Save query to the repository.
-	env.TickLock.RLock()
-	t := env.Tick
-	errS := env.Repo.Save(q, t)
-	env.TickLock.RUnlock()
+	errS := env.Repo.Save(q)
 	if errS != nil {
 		env.Log.Error(
 			"Error saving query.",
@@ -128,14 +107,116 @@ func Lookout(session *discordgo.Session, message *discordgo.MessageCreate, env *
 	}
 }
 
+// prepareQuery checks a query given in our more friendly format, and
+// translates it into a Bleve string query for an existing server, along with
+// the adventure packs to match, when filled in from a character. Any problem
+// is reported to the user, and the query is not ok.
+func prepareQuery(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv, query string) (string, []string, bool) {
+	errMessage := "There was an error processing the query: %s"
+	// Check that the query isn't too large.
+	if len(query) > queryLenMax {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Please keep queries below %d characters.", queryLenMax))
+		return "", nil, false
+	}
+	// Check for a "Changed:" field which may interfere.
+	if strings.Contains(query, "Changed:") {
+		session.ChannelMessageSend(message.ChannelID, "Query cannot contain the field \"Changed\".")
+		return "", nil, false
+	}
+	// Check for regex-delimitting forward-slashes.
+	if strings.ContainsRune(query, '/') {
+		session.ChannelMessageSend(message.ChannelID, "Query cannoth contain the forward-slash character (\"/\").")
+		return "", nil, false
+	}
+	query, packs, problem := fillCharacter(env, message.Author.ID, query)
+	if problem != "" {
+		session.ChannelMessageSend(message.ChannelID, problem)
+		return "", nil, false
+	}
+	s, err := translateQuery(query)
+	if err != nil {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf(errMessage, err.Error()))
+		return s, nil, false
+	}
+	// Check that the server is specified, and matches an existing server.
+	re := regexp.MustCompile(`\+?Server:\s*(\w+)`)
+	if !re.MatchString(s) {
+		if server := defaultServer(message, env); server != "" {
+			s = fmt.Sprintf("Server:%s %s", server, s)
+		}
+	}
+	sMatches := re.FindStringSubmatch(s)
+	if sMatches != nil {
+		server, suggestions := resolveServer(env, sMatches[1])
+		if server == "" {
+			session.ChannelMessageSend(message.ChannelID, didYouMean("server", sMatches[1], suggestions))
+			return s, nil, false
+		}
+		s = re.ReplaceAllString(s, "+Server: "+server)
+	} else {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf(errMessage, "Missing a server field."))
+		return s, nil, false
+	}
+	s, problem = replaceQuests(env, s)
+	if problem != "" {
+		session.ChannelMessageSend(message.ChannelID, problem)
+		return s, nil, false
+	}
+	// Check that the query isn't too costly to search each tick.
+	cost, err := matcher.QueryCost(s)
+	if err != nil {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf(errMessage, err.Error()))
+		return s, nil, false
+	}
+	if err := cost.Check(env.Config.QueryLimits); err != nil {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("That query would be too slow to search: %s.", err.Error()))
+		return s, nil, false
+	}
+	return s, packs, true
+}
+
 // Translates our slightly more friendly format into a valid Bleve string query.
 func translateQuery(s string) (string, error) {
 	return replaceLevel(s)
-	// TODO: Make sure Server field has Title-case.
+}
+
+var questField = regexp.MustCompile(`(Group\.Quest\.Name|\bQuest):\s*(?:"([^"]*)"|(\S+))`)
+
+// replaceQuests rewrites the quest names of quest fields to their names in the
+// quest catalog. Names outside the catalog are left be, unless they are close
+// to a quest in it, which is reported as the problem. As the catalog doesn't
+// have every quest, the names of quests groups are running are left be too.
+func replaceQuests(env *botenv.BotEnv, s string) (string, string) {
+	var problem string
+	s = questField.ReplaceAllStringFunc(s, func(field string) string {
+		m := questField.FindStringSubmatch(field)
+		name := m[2] + m[3]
+		quest, suggestions := resolveQuest(name)
+		if quest == "" {
+			if len(suggestions) > 0 && problem == "" && !isLiveQuest(env, name) {
+				problem = didYouMean("quest", name, suggestions)
+			}
+			return field
+		}
+		return fmt.Sprintf("%s:\"%s\"", m[1], quest)
+	})
+	return s, problem
+}
+
+// isLiveQuest reports whether any current group is running the named quest.
+func isLiveQuest(env *botenv.BotEnv, name string) bool {
+	for _, serverMap := range env.Snapshot().Audit.Map {
+		for _, sGroup := range serverMap {
+			if strings.EqualFold(sGroup.Group.Quest.Name, name) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func replaceLevel(s string) (string, error) {
-	splits := regexp.MustCompile(`\s*Level:`).Split(s, 3)
+	splits := regexp.MustCompile(`\s*\bLevel:`).Split(s, 3)
 	// Return the string if no level field found.
 	if len(splits) < 2 {
 		return s, nil
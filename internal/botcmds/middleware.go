@@ -0,0 +1,220 @@
+package botcmds
+
+import (
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/metrics"
+
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// Middleware wraps the handler of the named command with behaviour common to
+// all commands. The handler being wrapped is command.Cmd.
+type Middleware func(name string, command Command) Handler
+
+// Wrap applies the middleware to the command, with the first middleware
+// given being the outermost.
+func Wrap(name string, command Command, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		command.Cmd = middleware[i](name, command)
+	}
+	return command.Cmd
+}
+
+// Logging logs every invocation of a command, along with how long it took.
+func Logging() Middleware {
+	return func(name string, command Command) Handler {
+		return func(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+			start := time.Now()
+			command.Cmd(session, message, env)
+			env.Log.Info(
+				"Command",
+				zap.String("command", name),
+				zap.String("user", message.Author.ID),
+				zap.String("channel", message.ChannelID),
+				zap.String("guild", message.GuildID),
+				zap.Duration("latency", time.Since(start)))
+		}
+	}
+}
+
+// Metrics counts every invocation of a command.
+func Metrics() Middleware {
+	return func(name string, command Command) Handler {
+		return func(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+			metrics.Commands.WithLabelValues(name).Inc()
+			command.Cmd(session, message, env)
+		}
+	}
+}
+
+// Recovery recovers from a panic within a command, logging it and letting the
+// user know something went wrong.
+func Recovery() Middleware {
+	return func(name string, command Command) Handler {
+		return func(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+			defer func() {
+				if r := recover(); r != nil {
+					env.Log.Error(
+						"Command panicked.",
+						zap.String("command", name),
+						zap.String("content", message.Content),
+						zap.Any("panic", r),
+						zap.Stack("stack"))
+					session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem running that command.")
+				}
+			}()
+			command.Cmd(session, message, env)
+		}
+	}
+}
+
+// RateLimit throttles commands separately per user and per channel. A user is
+// told once per window when they are being throttled, and ignored after.
+func RateLimit(users *RateLimiter, channels *RateLimiter) Middleware {
+	return func(name string, command Command) Handler {
+		return func(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+			ok, first := users.Allow(message.Author.ID)
+			if ok {
+				ok, first = channels.Allow(message.ChannelID)
+			}
+			if !ok {
+				if first {
+					session.ChannelMessageSend(message.ChannelID, "Commands are coming in too quickly, please wait a moment.")
+				}
+				env.Log.Debug(
+					"Command throttled.",
+					zap.String("command", name),
+					zap.String("user", message.Author.ID),
+					zap.String("channel", message.ChannelID))
+				return
+			}
+			command.Cmd(session, message, env)
+		}
+	}
+}
+
+// Permissions checks that the user has the command's required permissions in
+// the channel, or is the bot's owner for owner commands. Commands requiring
+// permissions can only be used in a server.
+func Permissions() Middleware {
+	return func(name string, command Command) Handler {
+		if command.OwnerOnly {
+			return func(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+				if env.Config.OwnerID == "" || message.Author.ID != env.Config.OwnerID {
+					session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Only the bot's owner can use %s.", name))
+					return
+				}
+				command.Cmd(session, message, env)
+			}
+		}
+		if command.Permission == 0 {
+			return command.Cmd
+		}
+		return func(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+			if hasPermission(session, message, env, name, command.Permission) {
+				command.Cmd(session, message, env)
+			}
+		}
+	}
+}
+
+// hasPermission checks that the message's author has the permission in its
+// channel, telling them if they don't.
+func hasPermission(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv, name string, permission int) bool {
+	if message.GuildID == "" {
+		session.ChannelMessageSend(message.ChannelID, "That command can only be used in a server.")
+		return false
+	}
+	return checkPermission(session, message, env, name, message.ChannelID, permission)
+}
+
+// targetChannel resolves a mentioned channel which a command will post to,
+// checking that it's in the message's server and that the author has the
+// permission there, and telling them if not.
+func targetChannel(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv, name, mention string, permission int) (string, bool) {
+	m := channelMention.FindStringSubmatch(mention)
+	if m == nil {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("%s is not a channel.", mention))
+		return "", false
+	}
+	channel, err := session.Channel(m[1])
+	if err != nil {
+		env.Log.Warn(
+			"Error retrieving channel.",
+			zap.String("command", name),
+			zap.Error(err))
+	}
+	if err != nil || channel.GuildID != message.GuildID {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("%s is not a channel in this server.", mention))
+		return "", false
+	}
+	return channel.ID, checkPermission(session, message, env, name, channel.ID, permission)
+}
+
+// checkPermission checks that the message's author has the permission in the
+// channel, telling them if they don't.
+func checkPermission(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv, name, channelID string, permission int) bool {
+	perms, err := session.UserChannelPermissions(message.Author.ID, channelID)
+	if err != nil {
+		env.Log.Error(
+			"Error retrieving user's permissions.",
+			zap.String("command", name),
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem checking your permissions.")
+		return false
+	}
+	if perms&discordgo.PermissionAdministrator == 0 && perms&permission != permission {
+		if channelID != message.ChannelID {
+			session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("You don't have the permissions needed to use %s in <#%s>.", name, channelID))
+			return false
+		}
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("You don't have the permissions needed to use %s.", name))
+		return false
+	}
+	return true
+}
+
+// RateLimiter counts events per key within fixed windows of time.
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+	lock   sync.Mutex
+	counts map[string]*rateCount
+}
+
+type rateCount struct {
+	start time.Time
+	n     int
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{limit: limit, window: window, counts: make(map[string]*rateCount)}
+}
+
+// Allow counts an event for the key, and returns whether it is within the
+// limit, and whether it is the first event over the limit in its window.
+func (r *RateLimiter) Allow(key string) (bool, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := time.Now()
+	c, ok := r.counts[key]
+	if !ok || now.Sub(c.start) >= r.window {
+		// Drop expired windows now and then, so the map doesn't grow unbounded.
+		if len(r.counts) > 1024 {
+			for k, v := range r.counts {
+				if now.Sub(v.start) >= r.window {
+					delete(r.counts, k)
+				}
+			}
+		}
+		c = &rateCount{start: now}
+		r.counts[key] = c
+	}
+	c.n++
+	return c.n <= r.limit, c.n == r.limit+1
+}
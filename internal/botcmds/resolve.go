@@ -0,0 +1,135 @@
+package botcmds
+
+import (
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/quests"
+
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// The most suggestions given for a name which wasn't found.
+	suggestionsMax int = 3
+)
+
+// resolveServer finds the server by name, ignoring case. If it isn't found,
+// the names of any servers close to it are returned instead.
+func resolveServer(env *botenv.BotEnv, name string) (string, []string) {
+	auditMap := env.Snapshot().Audit.Map
+	servers := make([]string, 0, len(auditMap))
+	for s := range auditMap {
+		servers = append(servers, s)
+	}
+	for _, s := range servers {
+		if strings.EqualFold(s, name) {
+			return s, nil
+		}
+	}
+	return "", suggest(name, servers)
+}
+
+// resolveQuest finds the quest by name or alias in the quest catalog. If it
+// isn't found, the names of any quests close to it are returned instead.
+func resolveQuest(name string) (string, []string) {
+	if q, ok := quests.Lookup(name); ok {
+		return q.Name, nil
+	}
+	var names []string
+	canonical := make(map[string]string)
+	for _, q := range quests.All() {
+		for _, n := range append([]string{q.Name}, q.Aliases...) {
+			names = append(names, n)
+			canonical[n] = q.Name
+		}
+	}
+	var suggestions []string
+	for _, n := range suggest(name, names) {
+		if !containsString(suggestions, canonical[n]) {
+			suggestions = append(suggestions, canonical[n])
+		}
+	}
+	return "", suggestions
+}
+
+// didYouMean explains that the name wasn't found, along with any
+// suggestions.
+func didYouMean(kind string, name string, suggestions []string) string {
+	msg := fmt.Sprintf("No %s named *%s* was found.", kind, name)
+	if len(suggestions) == 0 {
+		return msg
+	}
+	quoted := make([]string, len(suggestions))
+	for i, s := range suggestions {
+		quoted[i] = "*" + s + "*"
+	}
+	if len(quoted) == 1 {
+		return fmt.Sprintf("%s Did you mean %s?", msg, quoted[0])
+	}
+	return fmt.Sprintf("%s Did you mean %s or %s?", msg,
+		strings.Join(quoted[:len(quoted)-1], ", "), quoted[len(quoted)-1])
+}
+
+// suggest returns up to suggestionsMax of the known names within a few edits
+// of the name, closest first. Longer names are allowed more edits.
+func suggest(name string, known []string) []string {
+	name = strings.ToLower(name)
+	maxDist := 1 + len(name)/4
+	type candidate struct {
+		name string
+		dist int
+	}
+	var candidates []candidate
+	for _, k := range known {
+		if d := editDistance(name, strings.ToLower(k)); d <= maxDist {
+			candidates = append(candidates, candidate{k, d})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].dist != candidates[j].dist {
+			return candidates[i].dist < candidates[j].dist
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	var suggestions []string
+	for _, c := range candidates {
+		if len(suggestions) == suggestionsMax {
+			break
+		}
+		suggestions = append(suggestions, c.name)
+	}
+	return suggestions
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	curr := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		curr[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(t)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,117 @@
+package botcmds
+
+import (
+	"lfm_lookout/internal/botenv"
+
+	"fmt"
+	"testing"
+)
+
+func TestDidYouMean(t *testing.T) {
+	tests := []struct {
+		suggestions []string
+		want        string
+	}{
+		{nil, "No quest named *Kiling Tim* was found."},
+		{[]string{"Killing Time"}, "No quest named *Kiling Tim* was found. Did you mean *Killing Time*?"},
+		{[]string{"Killing Time", "Kings Forest"}, "No quest named *Kiling Tim* was found. Did you mean *Killing Time* or *Kings Forest*?"},
+		{[]string{"A", "B", "C"}, "No quest named *Kiling Tim* was found. Did you mean *A*, *B* or *C*?"},
+	}
+	for _, tt := range tests {
+		if got := didYouMean("quest", "Kiling Tim", tt.suggestions); got != tt.want {
+			t.Errorf("didYouMean with %v = %q, want %q", tt.suggestions, got, tt.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	servers := []string{"Argonnessen", "Cannith", "Ghallanda", "Khyber", "Orien", "Sarlona", "Thelanis", "Wayfinder"}
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"Canith", []string{"Cannith"}},
+		{"cannith", []string{"Cannith"}},
+		{"KHYBR", []string{"Khyber"}},
+		{"Thelanus", []string{"Thelanis"}},
+		// Short names are allowed fewer edits.
+		{"Oren", []string{"Orien"}},
+		{"Orn", nil},
+		{"Lamannia", nil},
+	}
+	for _, tt := range tests {
+		if got := suggest(tt.name, servers); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("suggest(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	// Closest first, then by name, up to suggestionsMax.
+	got := suggest("ab", []string{"abc", "abd", "b", "ab_", "xy"})
+	if fmt.Sprint(got) != "[ab_ abc abd]" {
+		t.Errorf("suggest(\"ab\") = %v, want [ab_ abc abd]", got)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"kt", "", 2},
+		{"", "kt", 2},
+		{"cannith", "cannith", 0},
+		{"canith", "cannith", 1},
+		{"kitten", "sitting", 3},
+		{"baba's", "babas", 1},
+		{"ätz", "atz", 1},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestResolveQuest(t *testing.T) {
+	tests := []struct {
+		name        string
+		want        string
+		suggestions []string
+	}{
+		{"KT", "Killing Time", nil},
+		{"killing time", "Killing Time", nil},
+		{"Kiling Time", "", []string{"Killing Time"}},
+		// Suggestions name the quests, though their aliases were the close
+		// names.
+		{"VoDD", "", []string{"Vision of Destruction", "Tower of Despair"}},
+		{"Nothing Like Any Quest", "", nil},
+	}
+	for _, tt := range tests {
+		got, suggestions := resolveQuest(tt.name)
+		if got != tt.want || fmt.Sprint(suggestions) != fmt.Sprint(tt.suggestions) {
+			t.Errorf("resolveQuest(%q) = %q, %v, want %q, %v", tt.name, got, suggestions, tt.want, tt.suggestions)
+		}
+	}
+}
+
+func TestReplaceQuests(t *testing.T) {
+	env := &botenv.BotEnv{}
+	tests := []struct {
+		query   string
+		want    string
+		problem string
+	}{
+		{"+Server: Cannith Quest:KT", "+Server: Cannith Quest:\"Killing Time\"", ""},
+		{"Quest:\"old baba's hut\" Raid:yes", "Quest:\"Old Baba's Hut\" Raid:yes", ""},
+		{"Group.Quest.Name:VoD", "Group.Quest.Name:\"Vision of Destruction\"", ""},
+		{"Quest:Unheard", "Quest:Unheard", ""},
+		{"Quest:\"Kiling Time\"", "Quest:\"Kiling Time\"", "No quest named *Kiling Time* was found. Did you mean *Killing Time*?"},
+		{"Comment:kt", "Comment:kt", ""},
+	}
+	for _, tt := range tests {
+		got, problem := replaceQuests(env, tt.query)
+		if got != tt.want || problem != tt.problem {
+			t.Errorf("replaceQuests(%q) = %q, %q, want %q, %q", tt.query, got, problem, tt.want, tt.problem)
+		}
+	}
+}
@@ -18,13 +18,11 @@ var ServersHelp = discordgo.MessageEmbed{
 
 // [prefix]servers
 // Retrieves all server names currently contained as keys in the audit map.
-func Servers(session *discordgo.Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
-	env.AuditLock.RLock()
-	defer env.AuditLock.RUnlock()
+func Servers(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
 	var b strings.Builder
 
 	i := 0
-	for k := range env.Audit.Map {
+	for k := range env.Snapshot().Audit.Map {
 		fmt.Fprintf(&b, "%s\n", k)
 		i++
 	}
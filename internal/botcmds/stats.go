@@ -0,0 +1,163 @@
+package botcmds
+
+import (
+	"lfm_lookout/internal/archive"
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/chart"
+
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+const (
+	// The number of quests and packs listed.
+	statsTop int = 5
+)
+
+var StatsHelp = discordgo.MessageEmbed{
+	Title: "Stats Command",
+	Description: "*[prefix]stats [server] (chart)*\n\n" +
+		"Returns the LFM activity recorded for the specified server, or the server's default server:" +
+		" groups posted per hour of the day and per weekday, the most-run quests and packs," +
+		" and how long raids take to fill. With *chart*, the hours and weekdays are also drawn as charts.\n" +
+		"Ex: `lo!stats Cannith chart`",
+}
+
+var (
+	weekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+	sparks   = []rune("▁▂▃▄▅▆▇█")
+)
+
+// [prefix]stats [server] (chart)
+// Summarizes the server's groups from the archive, and sends the summary
+// formatted, along with charts if requested.
+func Stats(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	if env.Archive == nil {
+		session.ChannelMessageSend(message.ChannelID, "Statistics aren't available, as groups aren't being archived.")
+		return
+	}
+	strTokens := strings.Fields(message.Content)
+	var server string
+	var charts bool
+	for _, t := range strTokens[1:] {
+		if strings.ToLower(t) == "chart" {
+			charts = true
+		} else if server == "" {
+			server = t
+		}
+	}
+	if server == "" {
+		server = defaultServer(message, env)
+	}
+	if server == "" {
+		session.ChannelMessageSend(message.ChannelID, "No server argument found.")
+		return
+	}
+	name := server
+	server, suggestions := resolveServer(env, name)
+	if server == "" {
+		session.ChannelMessageSend(message.ChannelID, didYouMean("server", name, suggestions))
+		return
+	}
+	stats, err := env.Archive.Stats(server, statsTop)
+	if err != nil {
+		env.Log.Error(
+			"Error retrieving server statistics.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	if stats.Groups == 0 {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("No groups have been recorded for %s.", server))
+		return
+	}
+	embed := statsEmbed(stats)
+	if !charts {
+		session.ChannelMessageSendEmbed(message.ChannelID, embed)
+		return
+	}
+	hourLabels := make([]string, 24)
+	for i := range hourLabels {
+		hourLabels[i] = fmt.Sprintf("%02d", i)
+	}
+	hours, err := chart.Bars(fmt.Sprintf("%s groups by hour (UTC)", server), hourLabels, stats.ByHour[:])
+	if err != nil {
+		env.Log.Error(
+			"Error drawing chart.",
+			zap.Error(err))
+		session.ChannelMessageSendEmbed(message.ChannelID, embed)
+		return
+	}
+	days, err := chart.Bars(fmt.Sprintf("%s groups by weekday (UTC)", server), weekdays, stats.ByWeekday[:])
+	if err != nil {
+		env.Log.Error(
+			"Error drawing chart.",
+			zap.Error(err))
+		session.ChannelMessageSendEmbed(message.ChannelID, embed)
+		return
+	}
+	embed.Image = &discordgo.MessageEmbedImage{URL: "attachment://hours.png"}
+	session.ChannelMessageSendComplex(message.ChannelID, &discordgo.MessageSend{
+		Embed: embed,
+		Files: []*discordgo.File{
+			{Name: "hours.png", ContentType: "image/png", Reader: bytes.NewReader(hours)},
+			{Name: "weekdays.png", ContentType: "image/png", Reader: bytes.NewReader(days)},
+		},
+	})
+}
+
+func statsEmbed(stats archive.Stats) *discordgo.MessageEmbed {
+	// Hours as a sparkline, along with the busiest of them.
+	var hours strings.Builder
+	busiest := 0
+	for h, n := range stats.ByHour {
+		if n > stats.ByHour[busiest] {
+			busiest = h
+		}
+	}
+	for _, n := range stats.ByHour {
+		i := 0
+		if stats.ByHour[busiest] > 0 {
+			i = n * (len(sparks) - 1) / stats.ByHour[busiest]
+		}
+		hours.WriteRune(sparks[i])
+	}
+	hourValue := fmt.Sprintf("`%s`\n`00    06    12    18   `\nBusiest: %02d:00-%02d:00 (%d group(s))",
+		hours.String(), busiest, (busiest+1)%24, stats.ByHour[busiest])
+	days := make([]string, len(weekdays))
+	for i, d := range weekdays {
+		days[i] = fmt.Sprintf("%s %d", d, stats.ByWeekday[i])
+	}
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Groups by hour (UTC)", Value: hourValue},
+		{Name: "Groups by weekday (UTC)", Value: strings.Join(days, " | ")},
+		{Name: "Most-run quests", Value: countsValue(stats.Quests), Inline: true},
+		{Name: "Most-run packs", Value: countsValue(stats.Packs), Inline: true},
+	}
+	fill := "No raids have been seen both posted and started."
+	if stats.RaidsFilled > 0 {
+		fill = fmt.Sprintf("%s on average, across %d raid(s)", stats.RaidFill.Round(time.Minute), stats.RaidsFilled)
+	}
+	fields = append(fields, &discordgo.MessageEmbedField{Name: "Raid time-to-fill", Value: fill})
+	return &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("%s Statistics", stats.Server),
+		Description: fmt.Sprintf("%d group(s) recorded since %s.", stats.Groups, stats.Since.UTC().Format("Jan 2, 2006")),
+		Fields:      fields,
+	}
+}
+
+func countsValue(counts []archive.Count) string {
+	if len(counts) == 0 {
+		return "None recorded."
+	}
+	var b strings.Builder
+	for i, c := range counts {
+		fmt.Fprintf(&b, "%d. %s (%d)\n", i+1, c.Name, c.N)
+	}
+	return b.String()
+}
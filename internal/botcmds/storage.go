@@ -0,0 +1,76 @@
+package botcmds
+
+import (
+	"lfm_lookout/internal/botenv"
+
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+var StorageHelp = discordgo.MessageEmbed{
+	Title: "Storage Command",
+	Description: "*[prefix]storage*\n\n" +
+		"Returns the size of the bot's store, the number of keys of each kind, and when it was last tidied and backed up." +
+		" Only the bot's owner can use this command.\n" +
+		"Ex: `lo!storage`",
+}
+
+// [prefix]storage
+// Reports the store's statistics to the bot's owner.
+func Storage(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	stats, err := env.Repo.Stats()
+	if err != nil {
+		env.Log.Error(
+			"Error measuring the store.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	prefixes := make([]string, 0, len(stats.Keys))
+	for prefix := range stats.Keys {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	var keys strings.Builder
+	for _, prefix := range prefixes {
+		fmt.Fprintf(&keys, "%s: %d\n", prefix, stats.Keys[prefix])
+	}
+	fmt.Fprintf(&keys, "**Total: %d**", stats.Total)
+	lastGC := "Not since starting."
+	if !stats.LastGC.IsZero() {
+		lastGC = fmt.Sprintf("%s ago", time.Since(stats.LastGC).Round(time.Second))
+	}
+	lastBackup := "Not since starting."
+	if stats.LastBackup != "" {
+		lastBackup = stats.LastBackup
+	}
+	session.ChannelMessageSendEmbed(message.ChannelID, &discordgo.MessageEmbed{
+		Title: "Storage",
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "LSM Tree", Value: formatBytes(stats.LSMSize), Inline: true},
+			{Name: "Value Log", Value: formatBytes(stats.VLogSize), Inline: true},
+			{Name: "Keys", Value: keys.String()},
+			{Name: "Garbage Collected", Value: lastGC, Inline: true},
+			{Name: "Last Backup", Value: lastBackup, Inline: true},
+		},
+	})
+}
+
+// formatBytes formats a size in bytes in the largest fitting unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,150 @@
+package botcmds
+
+import (
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/lodb"
+
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+var WatchHelp = discordgo.MessageEmbed{
+	Title: "Watch Command",
+	Description: "*[prefix]watch*\n" +
+		"*[prefix]watch (server) [group id]*\n" +
+		"*[prefix]watch stop (server) [group id]*\n\n" +
+		"Lists the groups you are tracking, or starts or stops tracking a group, by the id shown after its # in listings." +
+		" The server can be left out in a server with a default server configured." +
+		" You are notified as players join and leave the group, and as its comment changes," +
+		" until it fills, starts or disbands." +
+		fmt.Sprintf(" Groups are tracked for at most %s, and you can track up to %d at once.\n", lodb.TRACKTTL, lodb.TRACKMAX) +
+		"Ex: `lo!watch Cannith 123456`",
+}
+
+// [prefix]watch ((stop) (server) [group id])
+// Retrieves the groups the user is tracking and returns them formatted, or
+// starts or stops tracking a group.
+func Watch(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	strTokens := strings.Fields(message.Content)[1:]
+	if len(strTokens) == 0 {
+		listTracks(session, message, env)
+		return
+	}
+	stop := strings.ToLower(strTokens[0]) == "stop"
+	if stop {
+		strTokens = strTokens[1:]
+	}
+	var name string
+	switch len(strTokens) {
+	case 1:
+		name = defaultServer(message, env)
+		if name == "" {
+			session.ChannelMessageSend(message.ChannelID, "No server argument found.")
+			return
+		}
+	case 2:
+		name = strTokens[0]
+	default:
+		session.ChannelMessageSend(message.ChannelID, "Please specify a server and a group id.")
+		return
+	}
+	server, suggestions := resolveServer(env, name)
+	if server == "" {
+		session.ChannelMessageSend(message.ChannelID, didYouMean("server", name, suggestions))
+		return
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(strTokens[len(strTokens)-1], "#"), 10, 64)
+	if err != nil {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("%s is not a group id.", strTokens[len(strTokens)-1]))
+		return
+	}
+	t := lodb.Track{
+		UserID:    message.Author.ID,
+		ChannelID: message.ChannelID,
+		Server:    server,
+		GroupID:   id,
+	}
+	if stop {
+		switch err := env.Repo.DeleteTrack(t); err {
+		case nil:
+			session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Stopped tracking group #%d.", id))
+		case lodb.ErrTrackNotFound:
+			session.ChannelMessageSend(message.ChannelID, "You aren't tracking that group.")
+		default:
+			env.Log.Error(
+				"Error deleting tracked group.",
+				zap.Error(err))
+			session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		}
+		return
+	}
+	sGroup, listed := env.Snapshot().Audit.Map[server][fmt.Sprintf("%d", id)]
+	switch {
+	case !listed:
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("No group #%d was found on %s.", id, server))
+		return
+	case sGroup.Group.AdventureActive > 0:
+		session.ChannelMessageSend(message.ChannelID, "That group has already started.")
+		return
+	case sGroup.IsFull():
+		session.ChannelMessageSend(message.ChannelID, "That group is already full.")
+		return
+	}
+	if message.GuildID != "" {
+		settings, err := env.Repo.FindGuildSettings(message.GuildID)
+		if err != nil {
+			env.Log.Error(
+				"Error retrieving guild settings.",
+				zap.Error(err))
+		}
+		t.Style = settings.Style
+	}
+	switch err := env.Repo.SaveTrack(t); err {
+	case nil:
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Tracking group #%d on %s.", id, server))
+	case lodb.ErrUserTracksFull:
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("You can only track up to %d groups at once.", lodb.TRACKMAX))
+	default:
+		env.Log.Error(
+			"Error saving tracked group.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+	}
+}
+
+func listTracks(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	tracks, err := env.Repo.FindTracksByUser(message.Author.ID)
+	if err != nil {
+		env.Log.Error(
+			"Error retrieving tracked groups.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	if len(tracks) == 0 {
+		session.ChannelMessageSend(message.ChannelID, "You aren't tracking any groups.")
+		return
+	}
+	fields := make([]*discordgo.MessageEmbedField, len(tracks))
+	auditMap := env.Snapshot().Audit.Map
+	for i, t := range tracks {
+		value := "No longer listed."
+		if sGroup, ok := auditMap[t.Server][fmt.Sprintf("%d", t.GroupID)]; ok {
+			value = sGroup.String()
+		}
+		fields[i] = &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("%s #%d", t.Server, t.GroupID),
+			Value: value,
+		}
+	}
+	session.ChannelMessageSendEmbed(message.ChannelID, &discordgo.MessageEmbed{
+		Title:       "Tracked Groups",
+		Description: botenv.StaleNote(env.Stale(time.Now())),
+		Fields:      fields,
+	})
+}
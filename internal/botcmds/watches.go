@@ -0,0 +1,181 @@
+package botcmds
+
+import (
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/lodb"
+
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+var WatchesHelp = discordgo.MessageEmbed{
+	Title: "Watches Command",
+	Description: "*[prefix]watches*\n" +
+		"*[prefix]watches add [#channel] (@role) [query]*\n" +
+		"*[prefix]watches remove [watch id]*\n\n" +
+		"Lists the server's watches, or adds or removes one. A watch posts every group" +
+		" matching its query to a channel, optionally mentioning a role, until it is removed." +
+		" Queries are written as with the lookout command, without a duration." +
+		" Adding and removing watches requires the Manage Channels permission.\n" +
+		"Ex: `lo!watches add #raid-lfm @Raiders Server:Cannith +Raid`",
+}
+
+var roleMention = regexp.MustCompile(`^<@&(\d+)>$`)
+
+// [prefix]watches ([add|remove] ...)
+// Retrieves the guild's watches from the query database and returns them
+// formatted, or adds or removes a watch for members allowed to manage
+// channels.
+func Watches(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	if message.GuildID == "" {
+		session.ChannelMessageSend(message.ChannelID, "That command can only be used in a server.")
+		return
+	}
+	strTokens := strings.Fields(message.Content)
+	if len(strTokens) == 1 {
+		listWatches(session, message, env)
+		return
+	}
+	switch strings.ToLower(strTokens[1]) {
+	case "add":
+		if hasPermission(session, message, env, "watches add", discordgo.PermissionManageChannels) {
+			addWatch(session, message, env, strTokens)
+		}
+	case "remove":
+		if hasPermission(session, message, env, "watches remove", discordgo.PermissionManageChannels) {
+			removeWatch(session, message, env, strTokens)
+		}
+	default:
+		session.ChannelMessageSend(message.ChannelID, "Please specify whether to add or remove a watch.")
+	}
+}
+
+func listWatches(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv) {
+	watches, err := env.Repo.FindWatchesByGuild(message.GuildID)
+	if err != nil {
+		env.Log.Error(
+			"Error retrieving guild's watches.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	if len(watches) == 0 {
+		session.ChannelMessageSend(message.ChannelID, "No watches found.")
+		return
+	}
+	fields := make([]*discordgo.MessageEmbedField, len(watches))
+	for i, w := range watches {
+		to := fmt.Sprintf("<#%s>", w.ChannelID)
+		if w.RoleID != "" {
+			to += fmt.Sprintf(" <@&%s>", w.RoleID)
+		}
+		fields[i] = &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("ID: %d", w.ID),
+			Value: fmt.Sprintf("%s\n*Posts to:* %s", w.Query, to),
+		}
+	}
+	embed := &discordgo.MessageEmbed{
+		Title:  "Watches",
+		Fields: fields,
+	}
+	session.ChannelMessageSendEmbed(message.ChannelID, embed)
+}
+
+func addWatch(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv, strTokens []string) {
+	if len(strTokens) < 4 {
+		session.ChannelMessageSend(message.ChannelID, "Please specify a channel and a query for the watch.")
+		return
+	}
+	channelID, ok := targetChannel(session, message, env, "watches add", strTokens[2], discordgo.PermissionManageChannels)
+	if !ok {
+		return
+	}
+	w := lodb.Watch{
+		GuildID:   message.GuildID,
+		ChannelID: channelID,
+		CreatorID: message.Author.ID,
+	}
+	n := 3
+	if role := roleMention.FindStringSubmatch(strTokens[3]); role != nil {
+		w.RoleID = role[1]
+		n++
+	}
+	raw := afterTokens(message.Content, n)
+	if strings.Contains(raw, "Duration:") {
+		session.ChannelMessageSend(message.ChannelID, "Watches last until they are removed, and don't take a duration.")
+		return
+	}
+	s, packs, ok := prepareQuery(session, message, env, raw)
+	if !ok {
+		return
+	}
+	settings, err := env.Repo.FindGuildSettings(message.GuildID)
+	if err != nil {
+		env.Log.Error(
+			"Error retrieving guild settings.",
+			zap.Error(err))
+	}
+	w.Query = s
+	w.Packs = packs
+	w.Style = settings.Style
+	w.Created = time.Now()
+	id, errS := env.Repo.SaveWatch(w)
+	if errS == lodb.ErrGuildWatchesFull {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("This server already has %d watches.", lodb.WATCHMAX))
+	} else if errS != nil {
+		env.Log.Error(
+			"Error saving watch.",
+			zap.Error(errS))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+	} else {
+		env.Log.Info(
+			"New Watch",
+			zap.String("guild", w.GuildID),
+			zap.String("query", s))
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Watch %d saved.", id))
+	}
+}
+
+func removeWatch(session Session, message *discordgo.MessageCreate, env *botenv.BotEnv, strTokens []string) {
+	if len(strTokens) < 3 {
+		session.ChannelMessageSend(message.ChannelID, "Please specify the ID of the watch to remove.")
+		return
+	}
+	id, err := strconv.Atoi(strTokens[2])
+	if err != nil {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("%s is not a watch ID.", strTokens[2]))
+		return
+	}
+	err = env.Repo.DeleteWatch(message.GuildID, id)
+	if err == lodb.ErrWatchNotFound {
+		session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("No watch with the ID %d was found.", id))
+		return
+	} else if err != nil {
+		env.Log.Error(
+			"Error deleting watch.",
+			zap.Error(err))
+		session.ChannelMessageSend(message.ChannelID, "Oh dear, it seems like there was a problem.")
+		return
+	}
+	session.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Watch %d was removed.", id))
+}
+
+// afterTokens returns what follows the first n whitespace-separated tokens
+// of s, keeping its spacing intact.
+func afterTokens(s string, n int) string {
+	for i := 0; i < n; i++ {
+		s = strings.TrimLeft(s, " \t\n")
+		if j := strings.IndexAny(s, " \t\n"); j >= 0 {
+			s = s[j:]
+		} else {
+			return ""
+		}
+	}
+	return s
+}
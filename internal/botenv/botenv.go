@@ -1,38 +1,147 @@
 package botenv
 
 import (
-	"lfm_lookout/internal/audit"
+	"lfm_lookout/internal/archive"
 	"lfm_lookout/internal/lodb"
+	"lfm_lookout/internal/matcher"
 
-	"sync"
+	"fmt"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 )
 
-type SearchableGroup struct {
-	Server  string
-	Group   audit.Group
-	Members int
-	Fresh   bool
+type AuditMap struct {
+	Map map[string]map[string]matcher.SearchableGroup
 }
 
-type AuditMap struct {
-	Map map[string]map[string]SearchableGroup
+// Index matches queries against a snapshot's groups.
+type Index interface {
+	Match(q string, filter matcher.Filter, size int) ([]matcher.SearchableGroup, error)
+	Close() error
+}
+
+// Snapshot is the groups as of a tick. A published snapshot is never
+// modified, so it can be read without locking.
+type Snapshot struct {
+	// map[audit.Server.Name]map["audit.Group.Id"]audit.Group
+	Audit AuditMap
+	// The index of the audit's groups, nil until the first tick. It is closed
+	// once the snapshot after next is published.
+	Index Index
+	// When the audit was fetched.
+	Time time.Time
 }
 
 type BotEnv struct {
 	Config *Configuration
 	Log    *zap.Logger
 	Repo   *lodb.LoRepo
-	// map[audit.Server.Name]map["audit.Group.Id"]audit.Group
-	Audit     AuditMap
-	AuditLock *sync.RWMutex
-	Tick      rune
-	TickLock  *sync.RWMutex
+	// Archive is nil when archiving is disabled.
+	Archive *archive.Archive
+	// Holds the current *Snapshot.
+	snapshot atomic.Value
+}
+
+// Snapshot gives the current snapshot, which is empty until one is
+// published.
+func (env *BotEnv) Snapshot() *Snapshot {
+	s, ok := env.snapshot.Load().(*Snapshot)
+	if !ok {
+		return &Snapshot{}
+	}
+	return s
+}
+
+// Publish makes the snapshot current, and returns the one it replaces. Only
+// the scheduler publishes, one tick at a time.
+func (env *BotEnv) Publish(s *Snapshot) *Snapshot {
+	prev := env.Snapshot()
+	env.snapshot.Store(s)
+	return prev
+}
+
+// Stale gives how old the current snapshot's groups are, once they're older
+// than staleAudits audit periods, and otherwise 0.
+func (env *BotEnv) Stale(now time.Time) time.Duration {
+	s := env.Snapshot()
+	if s.Time.IsZero() {
+		return 0
+	}
+	age := now.Sub(s.Time)
+	if age <= time.Second*time.Duration(staleAudits*env.Config.AuditPeriod) {
+		return 0
+	}
+	return age
+}
+
+// The number of audit periods after which groups are flagged as stale.
+const staleAudits = 3
+
+// StaleNote flags groups as stale in messages showing them, or is empty if
+// the groups aren't.
+func StaleNote(age time.Duration) string {
+	if age <= 0 {
+		return ""
+	}
+	if age < time.Hour*2 {
+		return fmt.Sprintf("*The group data is %d minutes old.*", int(age.Minutes()))
+	}
+	return fmt.Sprintf("*The group data is %d hours old.*", int(age.Hours()))
 }
 
 type Configuration struct {
-	Prefix string `json:"Prefix"`
-	Token  string `json:"Token"`
-	AuditPeriod int `json:"AuditPeriod"`
+	Prefix      string `json:"Prefix"`
+	Token       string `json:"Token"`
+	AuditPeriod int    `json:"AuditPeriod"`
+	// The number of commands a user, or a channel, may send within the
+	// rate window, in seconds.
+	UserRateLimit    int `json:"UserRateLimit"`
+	ChannelRateLimit int `json:"ChannelRateLimit"`
+	RateWindow       int `json:"RateWindow"`
+	// Where the group archive is kept, and for how many days. An empty path
+	// disables the archive, and 0 days keeps groups forever.
+	ArchivePath          string `json:"ArchivePath"`
+	ArchiveRetentionDays int    `json:"ArchiveRetentionDays"`
+	// A dictionary of abbreviations to use in place of the bundled one, if
+	// any.
+	SynonymsPath string `json:"SynonymsPath"`
+	// The number of queries matched at once each tick. 0 uses one per CPU.
+	MatchWorkers int `json:"MatchWorkers"`
+	// The most a query may cost to search, checked as it's saved. Limits
+	// left out use the defaults, and a limit of 0 forbids that part of a
+	// query.
+	QueryLimits matcher.Limits `json:"QueryLimits"`
+	// A query searched slower than this, in milliseconds, is given a strike,
+	// and is disabled once it has QueryStrikes strikes in a row. 0 uses 50ms
+	// and 3 strikes.
+	SlowQueryMillis int `json:"SlowQueryMillis"`
+	QueryStrikes    int `json:"QueryStrikes"`
+	// The Discord user ID of the bot's owner, who may use owner commands.
+	OwnerID string `json:"OwnerID"`
+	// How often, in minutes, the store's value log garbage is collected, and
+	// the ratio of a value log file's data which must be stale for it to be
+	// rewritten. 0 uses 10 minutes and a ratio of 0.5.
+	GCPeriodMinutes int     `json:"GCPeriodMinutes"`
+	GCDiscardRatio  float64 `json:"GCDiscardRatio"`
+	// Where the store is backed up to, every BackupHours hours, keeping the
+	// newest BackupKeep backups. An empty directory disables backups, and
+	// 0 uses 24 hours and 7 backups.
+	BackupDir   string `json:"BackupDir"`
+	BackupHours int    `json:"BackupHours"`
+	BackupKeep  int    `json:"BackupKeep"`
+	// Files holding the hex key the store is encrypted with, and the key it
+	// was encrypted with before, while changing keys. The LOOKOUT_ENCRYPTION_KEY
+	// and LOOKOUT_OLD_ENCRYPTION_KEY environment variables are used instead
+	// when set. Without a key the store is kept in plaintext.
+	EncryptionKeyFile    string `json:"EncryptionKeyFile"`
+	OldEncryptionKeyFile string `json:"OldEncryptionKeyFile"`
+	// How often, in days, new data keys are made for the encrypted store. 0
+	// uses 10 days.
+	KeyRotationDays int `json:"KeyRotationDays"`
+	// Whether Prometheus metrics are served at /metrics, and the address to
+	// serve them on. An empty address uses localhost:2112.
+	MetricsEnabled bool   `json:"MetricsEnabled"`
+	MetricsAddress string `json:"MetricsAddress"`
 }
@@ -0,0 +1,95 @@
+package chart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strconv"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	width   = 640
+	height  = 240
+	margin  = 24
+	labelsH = 16
+)
+
+var (
+	background = color.RGBA{R: 0x2f, G: 0x31, B: 0x36, A: 0xff}
+	foreground = color.RGBA{R: 0xdc, G: 0xdd, B: 0xde, A: 0xff}
+	bar        = color.RGBA{R: 0x58, G: 0x65, B: 0xf2, A: 0xff}
+)
+
+// Bars draws a bar chart of the values as a PNG, with a title above and each
+// bar's label below it. Labels which would crowd each other are skipped.
+func Bars(title string, labels []string, values []int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: background}, image.Point{}, draw.Src)
+	face := basicfont.Face7x13
+	text(img, face, margin, margin-6, title)
+
+	max := 0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	if len(values) == 0 {
+		return encode(img)
+	}
+	top := margin + 4
+	bottom := height - margin - labelsH
+	slot := (width - 2*margin) / len(values)
+	labelEvery := 1
+	for labelW := 7 * longest(labels); labelEvery*slot < labelW+4; {
+		labelEvery++
+	}
+	for i, v := range values {
+		x := margin + i*slot
+		if max > 0 {
+			h := (bottom - top) * v / max
+			draw.Draw(img, image.Rect(x+1, bottom-h, x+slot-1, bottom), &image.Uniform{C: bar}, image.Point{}, draw.Src)
+		}
+		if i < len(labels) && i%labelEvery == 0 {
+			text(img, face, x+1, bottom+labelsH-2, labels[i])
+		}
+	}
+	// Baseline, and the value of the tallest bar.
+	draw.Draw(img, image.Rect(margin, bottom, width-margin, bottom+1), &image.Uniform{C: foreground}, image.Point{}, draw.Src)
+	if max > 0 {
+		text(img, face, width-margin-7*len(strconv.Itoa(max)), margin-6, strconv.Itoa(max))
+	}
+	return encode(img)
+}
+
+func text(img draw.Image, face font.Face, x, y int, s string) {
+	d := font.Drawer{
+		Dst:  img,
+		Src:  &image.Uniform{C: foreground},
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(s)
+}
+
+func encode(img image.Image) ([]byte, error) {
+	var b bytes.Buffer
+	err := png.Encode(&b, img)
+	return b.Bytes(), err
+}
+
+func longest(labels []string) int {
+	n := 0
+	for _, l := range labels {
+		if len(l) > n {
+			n = len(l)
+		}
+	}
+	return n
+}
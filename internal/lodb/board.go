@@ -0,0 +1,162 @@
+package lodb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+var (
+	ErrGuildBoardsFull = errors.New("the guild has no room for another board")
+	ErrBoardNotFound   = errors.New("no board found with that id")
+)
+
+const (
+	// The maximum number of boards a guild can have.
+	BOARDMAX int = 5
+)
+
+// Board is a set of messages which are edited every tick to show the groups
+// matching its query.
+type Board struct {
+	GuildID    string
+	ID         int // ID is unique per guild.
+	ChannelID  string
+	MessageIDs []string
+	CreatorID  string
+	Server     string
+	Query      string // String formatted for string query in Bleve
+	// As with LoQuery's packs.
+	Packs []string `json:",omitempty"`
+}
+
+// SaveBoard saves a new board under the lowest unused ID of its guild, and
+// returns the ID.
+func (r *LoRepo) SaveBoard(b Board) (int, error) {
+	err := r.db.Update(func(txn *badger.Txn) error {
+		used := make(map[int]bool)
+		err := iterateBoards(txn, fmt.Sprintf("board-%s-", b.GuildID), func(other Board) error {
+			used[other.ID] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(used) >= BOARDMAX {
+			return ErrGuildBoardsFull
+		}
+		b.ID = 1
+		for used[b.ID] {
+			b.ID++
+		}
+		return setBoard(txn, b)
+	})
+	return b.ID, err
+}
+
+// UpdateBoard saves changes to an existing board. A board which has since
+// been deleted is left deleted.
+func (r *LoRepo) UpdateBoard(b Board) error {
+	return r.db.Update(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(fmt.Sprintf("board-%s-%d", b.GuildID, b.ID)))
+		if err == badger.ErrKeyNotFound {
+			return ErrBoardNotFound
+		} else if err != nil {
+			return err
+		}
+		return setBoard(txn, b)
+	})
+}
+
+// DeleteBoard deletes the board, and returns it as it was.
+func (r *LoRepo) DeleteBoard(guildID string, id int) (Board, error) {
+	var b Board
+	err := r.db.Update(func(txn *badger.Txn) error {
+		key := []byte(fmt.Sprintf("board-%s-%d", guildID, id))
+		item, err := txn.Get(key)
+		if err == badger.ErrKeyNotFound {
+			return ErrBoardNotFound
+		} else if err != nil {
+			return err
+		}
+		err = item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &b)
+		})
+		if err != nil {
+			return err
+		}
+		return txn.Delete(key)
+	})
+	return b, err
+}
+
+// FindBoard retrieves one of the guild's boards.
+func (r *LoRepo) FindBoard(guildID string, id int) (Board, error) {
+	var b Board
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(fmt.Sprintf("board-%s-%d", guildID, id)))
+		if err == badger.ErrKeyNotFound {
+			return ErrBoardNotFound
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &b)
+		})
+	})
+	return b, err
+}
+
+// FindBoardsByGuild
+func (r *LoRepo) FindBoardsByGuild(guildID string) ([]Board, error) {
+	var boards []Board
+	err := r.db.View(func(txn *badger.Txn) error {
+		return iterateBoards(txn, fmt.Sprintf("board-%s-", guildID), func(b Board) error {
+			boards = append(boards, b)
+			return nil
+		})
+	})
+	return boards, err
+}
+
+// FindBoards retrieves the boards of every guild.
+func (r *LoRepo) FindBoards() ([]Board, error) {
+	var boards []Board
+	err := r.db.View(func(txn *badger.Txn) error {
+		return iterateBoards(txn, "board-", func(b Board) error {
+			boards = append(boards, b)
+			return nil
+		})
+	})
+	return boards, err
+}
+
+// board-[GuildID]-[ID]:[Board]
+func setBoard(txn *badger.Txn, b Board) error {
+	val, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return txn.Set([]byte(fmt.Sprintf("board-%s-%d", b.GuildID, b.ID)), val)
+}
+
+func iterateBoards(txn *badger.Txn, prefix string, fn func(Board) error) error {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	p := []byte(prefix)
+	for it.Seek(p); it.ValidForPrefix(p); it.Next() {
+		var b Board
+		err := it.Item().Value(func(v []byte) error {
+			return json.Unmarshal(v, &b)
+		})
+		if err != nil {
+			return err
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package lodb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+var (
+	ErrUserCharactersFull = errors.New("the user has no room for another character")
+	ErrCharacterNotFound  = errors.New("no character found with that name")
+)
+
+const (
+	// The maximum number of characters a user can register.
+	CHARMAX int = 10
+)
+
+// Character is a user's character, which their queries can be filled in
+// from.
+type Character struct {
+	UserID  string
+	Name    string
+	Server  string
+	Level   int
+	Classes []string
+	// The adventure packs the character has access to. VIP characters have
+	// access to them all.
+	Packs []string
+	VIP   bool
+	// Whether the user has shown they play the character, by leading a
+	// group with Code in its comment.
+	Verified bool
+	Code     string `json:",omitempty"`
+}
+
+func characterKey(userID, name string) []byte {
+	// char-[UserID]-[lowercase Name]:[Character]
+	return []byte(fmt.Sprintf("char-%s-%s", userID, strings.ToLower(name)))
+}
+
+// SaveCharacter saves the character, replacing any of the user's characters
+// of the same name.
+func (r *LoRepo) SaveCharacter(c Character) error {
+	val, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(txn *badger.Txn) error {
+		key := characterKey(c.UserID, c.Name)
+		if _, err := txn.Get(key); err == badger.ErrKeyNotFound {
+			n := 0
+			err := iterateCharacters(txn, fmt.Sprintf("char-%s-", c.UserID), func(Character) error {
+				n++
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if n >= CHARMAX {
+				return ErrUserCharactersFull
+			}
+		} else if err != nil {
+			return err
+		}
+		return txn.Set(key, val)
+	})
+}
+
+// FindCharacter retrieves one of the user's characters by name, ignoring
+// case.
+func (r *LoRepo) FindCharacter(userID, name string) (Character, error) {
+	var c Character
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(characterKey(userID, name))
+		if err == badger.ErrKeyNotFound {
+			return ErrCharacterNotFound
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &c)
+		})
+	})
+	return c, err
+}
+
+// FindCharactersByUser
+func (r *LoRepo) FindCharactersByUser(userID string) ([]Character, error) {
+	var chars []Character
+	err := r.db.View(func(txn *badger.Txn) error {
+		return iterateCharacters(txn, fmt.Sprintf("char-%s-", userID), func(c Character) error {
+			chars = append(chars, c)
+			return nil
+		})
+	})
+	return chars, err
+}
+
+// DeleteCharacter
+func (r *LoRepo) DeleteCharacter(userID, name string) error {
+	return r.db.Update(func(txn *badger.Txn) error {
+		key := characterKey(userID, name)
+		if _, err := txn.Get(key); err == badger.ErrKeyNotFound {
+			return ErrCharacterNotFound
+		} else if err != nil {
+			return err
+		}
+		return txn.Delete(key)
+	})
+}
+
+func iterateCharacters(txn *badger.Txn, prefix string, fn func(Character) error) error {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	p := []byte(prefix)
+	for it.Seek(p); it.ValidForPrefix(p); it.Next() {
+		var c Character
+		err := it.Item().Value(func(v []byte) error {
+			return json.Unmarshal(v, &c)
+		})
+		if err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,415 @@
+package lodb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+var (
+	ErrBadKey          = errors.New("an encryption key must be 16, 24 or 32 bytes, written in hex")
+	ErrBackupEncrypted = errors.New("the backup is encrypted, and no key was given")
+	ErrBackupCorrupt   = errors.New("the backup is corrupt, or was encrypted with another key")
+)
+
+// Encrypted backups start with this, followed by the nonce prefix of their
+// chunks.
+var backupMagic = []byte("LOBAKGC1")
+
+const (
+	// How much of a backup is sealed at a time.
+	backupChunk int = 64 << 10
+	// The random part of each chunk's nonce, which the chunk's number and
+	// whether it's the last follow.
+	backupPrefixSize int = 7
+)
+
+// RepoOptions configures how the store is opened.
+type RepoOptions struct {
+	// The key the store is encrypted with, or nil to keep it in plaintext. A
+	// plaintext store is encrypted as it's opened with a key.
+	EncryptionKey []byte
+	// The key the store was encrypted with, when it's being changed to
+	// EncryptionKey. Once it has been, the old key is only needed to restore
+	// backups taken before.
+	OldEncryptionKey []byte
+	// How often new data keys are made, which the store's files are
+	// encrypted with. 0 uses Badger's 10 days.
+	KeyRotation time.Duration
+}
+
+// ParseKey reads a hex encryption key, such as from a key file.
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, ErrBadKey
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, ErrBadKey
+}
+
+// OpenLoRepo opens the store at the path, encrypting it if it isn't, and
+// changing its key if asked to.
+func OpenLoRepo(path string, opts RepoOptions) (*LoRepo, error) {
+	if len(opts.EncryptionKey) == 0 {
+		return NewLoRepo(path)
+	}
+	if err := recoverMigration(path); err != nil {
+		return nil, err
+	}
+	plain, err := isPlaintext(path)
+	if err != nil {
+		return nil, err
+	}
+	if plain {
+		if err := migrate(path, opts); err != nil {
+			return nil, fmt.Errorf("encrypting the store: %w", err)
+		}
+	} else if len(opts.OldEncryptionKey) > 0 {
+		if err := rotateKey(path, opts); err != nil {
+			return nil, fmt.Errorf("changing the store's key: %w", err)
+		}
+	}
+	db, err := badger.Open(encryptedOptions(path, opts))
+	if err != nil {
+		return nil, err
+	}
+	return &LoRepo{db: db, key: opts.EncryptionKey, oldKey: opts.OldEncryptionKey}, nil
+}
+
+func encryptedOptions(path string, opts RepoOptions) badger.Options {
+	bOpts := badger.DefaultOptions(path).
+		WithEncryptionKey(opts.EncryptionKey).
+		// Encrypted blocks are cached decrypted, and Badger requires a cache.
+		WithBlockCacheSize(64 << 20).
+		WithIndexCacheSize(16 << 20)
+	if opts.KeyRotation > 0 {
+		bOpts = bOpts.WithEncryptionKeyRotationDuration(opts.KeyRotation)
+	}
+	return bOpts
+}
+
+func registryOptions(path string, key []byte) badger.KeyRegistryOptions {
+	return badger.KeyRegistryOptions{
+		Dir:           path,
+		ReadOnly:      true,
+		EncryptionKey: key,
+	}
+}
+
+// isPlaintext reports whether there is a store at the path which isn't
+// encrypted.
+func isPlaintext(path string) (bool, error) {
+	_, err := os.Stat(filepath.Join(path, badger.KeyRegistryFileName))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	reg, err := badger.OpenKeyRegistry(registryOptions(path, nil))
+	if errors.Is(err, badger.ErrEncryptionKeyMismatch) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, reg.Close()
+}
+
+// rotateKey re-encrypts the store's data keys with the new key, leaving the
+// data itself as it is. It does nothing if the store already has the new
+// key, so the old key can be left configured across restarts.
+func rotateKey(path string, opts RepoOptions) error {
+	reg, err := badger.OpenKeyRegistry(registryOptions(path, opts.EncryptionKey))
+	if err == nil {
+		return reg.Close()
+	} else if !errors.Is(err, badger.ErrEncryptionKeyMismatch) {
+		return err
+	}
+	reg, err = badger.OpenKeyRegistry(registryOptions(path, opts.OldEncryptionKey))
+	if err != nil {
+		return err
+	}
+	defer reg.Close()
+	return badger.WriteKeyRegistry(reg, badger.KeyRegistryOptions{
+		Dir:           path,
+		EncryptionKey: opts.EncryptionKey,
+	})
+}
+
+// migrate copies the plaintext store into a new encrypted one beside it,
+// swaps the two, and removes the plaintext store. Badger only encrypts what
+// it writes, so the existing files can't be encrypted in place.
+func migrate(path string, opts RepoOptions) error {
+	tmpPath, plainPath := path+".encrypting", path+".plaintext"
+	// A copy left by an interrupted migration is started over.
+	if err := os.RemoveAll(tmpPath); err != nil {
+		return err
+	}
+	src, err := badger.Open(badger.DefaultOptions(path))
+	if err != nil {
+		return err
+	}
+	dst, err := badger.Open(encryptedOptions(tmpPath, opts))
+	if err != nil {
+		src.Close()
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := src.Backup(pw, 0)
+		pw.CloseWithError(err)
+	}()
+	errL := dst.Load(pr, 256)
+	pr.Close()
+	errS, errD := src.Close(), dst.Close()
+	for _, err := range []error{errL, errS, errD} {
+		if err != nil {
+			os.RemoveAll(tmpPath)
+			return err
+		}
+	}
+	if err := os.Rename(path, plainPath); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return os.RemoveAll(plainPath)
+}
+
+// recoverMigration puts the plaintext store back if a migration was
+// interrupted between moving it aside and moving the encrypted copy in.
+func recoverMigration(path string) error {
+	plainPath := path + ".plaintext"
+	if _, err := os.Stat(plainPath); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		// The encrypted copy made it in place.
+		return os.RemoveAll(plainPath)
+	}
+	return os.Rename(plainPath, path)
+}
+
+// backupWriter seals a backup being written in chunks with AES-GCM. Each
+// chunk's nonce holds its number, and whether it's the last, so chunks can't
+// be reordered, dropped or cut off without the backup failing to open.
+type backupWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	n      uint32
+	buf    []byte
+}
+
+// encryptBackup wraps a backup being written, encrypting it with the key.
+// The backup is only complete once the writer is closed.
+func encryptBackup(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newBackupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, backupPrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(append([]byte{}, backupMagic...), prefix...)); err != nil {
+		return nil, err
+	}
+	return &backupWriter{w: w, aead: aead, prefix: prefix}, nil
+}
+
+func (bw *backupWriter) Write(p []byte) (int, error) {
+	bw.buf = append(bw.buf, p...)
+	// A full chunk is held back until more follows, so the last chunk is
+	// never empty unless the backup is.
+	for len(bw.buf) > backupChunk {
+		if err := bw.seal(bw.buf[:backupChunk], false); err != nil {
+			return 0, err
+		}
+		bw.buf = append(bw.buf[:0], bw.buf[backupChunk:]...)
+	}
+	return len(p), nil
+}
+
+// Close seals the last chunk.
+func (bw *backupWriter) Close() error {
+	return bw.seal(bw.buf, true)
+}
+
+func (bw *backupWriter) seal(chunk []byte, last bool) error {
+	sealed := bw.aead.Seal(nil, chunkNonce(bw.prefix, bw.n, last), chunk, nil)
+	bw.n++
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(sealed)))
+	if _, err := bw.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := bw.w.Write(sealed)
+	return err
+}
+
+// backupReader opens the chunks of a backup being read, as sealed by
+// backupWriter.
+type backupReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	n      uint32
+	plain  []byte
+	done   bool
+}
+
+// decryptBackup unwraps a backup being read, decrypting it if it was
+// encrypted. A backup which fails to open gives ErrBackupCorrupt partway
+// through.
+func decryptBackup(r io.Reader, key []byte) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(backupMagic))
+	if err != nil || !bytes.Equal(magic, backupMagic) {
+		return br, nil
+	}
+	if len(key) == 0 {
+		return nil, ErrBackupEncrypted
+	}
+	aead, err := newBackupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(backupMagic)+backupPrefixSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, ErrBackupCorrupt
+	}
+	return &backupReader{r: br, aead: aead, prefix: header[len(backupMagic):]}, nil
+}
+
+func (br *backupReader) Read(p []byte) (int, error) {
+	for len(br.plain) == 0 {
+		if br.done {
+			return 0, io.EOF
+		}
+		if err := br.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, br.plain)
+	br.plain = br.plain[n:]
+	return n, nil
+}
+
+func (br *backupReader) open() error {
+	var size [4]byte
+	if _, err := io.ReadFull(br.r, size[:]); err != nil {
+		// Backups end with a chunk marked as the last.
+		return ErrBackupCorrupt
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > uint32(backupChunk+br.aead.Overhead()) {
+		return ErrBackupCorrupt
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(br.r, sealed); err != nil {
+		return ErrBackupCorrupt
+	}
+	_, err := br.r.Peek(1)
+	last := err == io.EOF
+	plain, err := br.aead.Open(sealed[:0], chunkNonce(br.prefix, br.n, last), sealed, nil)
+	if err != nil {
+		return ErrBackupCorrupt
+	}
+	br.n++
+	br.plain, br.done = plain, last
+	return nil
+}
+
+func newBackupAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, n uint32, last bool) []byte {
+	nonce := make([]byte, backupPrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[backupPrefixSize:], n)
+	if last {
+		nonce[backupPrefixSize+4] = 1
+	}
+	return nonce
+}
+
+// Restore loads a backup into the store, decrypting it with the store's key,
+// or else its old key, if it was encrypted. The backup is checked in full
+// before any of it is loaded.
+func (r *LoRepo) Restore(path string) error {
+	key, err := r.backupKey(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br, err := decryptBackup(f, key)
+	if err != nil {
+		return err
+	}
+	return r.db.Load(br, 256)
+}
+
+// backupKey gives which of the store's keys opens the backup, or nil if it
+// isn't encrypted.
+func (r *LoRepo) backupKey(path string) ([]byte, error) {
+	var keys [][]byte
+	for _, key := range [][]byte{r.key, r.oldKey} {
+		if len(key) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, checkBackup(path, nil)
+	}
+	var err error
+	for _, key := range keys {
+		if err = checkBackup(path, key); err == nil {
+			return key, nil
+		} else if !errors.Is(err, ErrBackupCorrupt) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// checkBackup reads the whole backup, decrypting it with the key.
+func checkBackup(path string, key []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br, err := decryptBackup(f, key)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, br)
+	return err
+}
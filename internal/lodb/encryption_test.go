@@ -0,0 +1,187 @@
+package lodb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	testKey    = bytes.Repeat([]byte{0x11}, 32)
+	testOldKey = bytes.Repeat([]byte{0x22}, 16)
+)
+
+// sealBackup encrypts the data as Backup would, in writes of the given size.
+func sealBackup(t *testing.T, data []byte, key []byte, writeSize int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := encryptBackup(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(data) > 0 {
+		n := writeSize
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func openBackup(sealed []byte, key []byte) ([]byte, error) {
+	r, err := decryptBackup(bytes.NewReader(sealed), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		writeSize int
+	}{
+		{"empty", 0, 1},
+		{"one byte", 1, 1},
+		{"under a chunk", backupChunk - 1, 4096},
+		{"a chunk", backupChunk, backupChunk},
+		{"just over a chunk", backupChunk + 1, 1000},
+		{"several chunks", backupChunk*3 + 17, 7919},
+		{"in one write", backupChunk*2 + 5, backupChunk*3 + 5},
+	}
+	for _, tt := range tests {
+		data := make([]byte, tt.size)
+		rand.Read(data)
+		sealed := sealBackup(t, data, testKey, tt.writeSize)
+		if tt.size >= 64 && bytes.Contains(sealed, data[:64]) {
+			t.Errorf("%s: the backup holds the plaintext", tt.name)
+		}
+		got, err := openBackup(sealed, testKey)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: read back %d bytes, want the %d written", tt.name, len(got), len(data))
+		}
+	}
+}
+
+func TestBackupTampering(t *testing.T) {
+	data := make([]byte, backupChunk*2+100)
+	rand.Read(data)
+	sealed := sealBackup(t, data, testKey, 4096)
+	header := len(backupMagic) + backupPrefixSize
+	firstChunk := header + 4 + int(binary.BigEndian.Uint32(sealed[header:]))
+	secondChunk := firstChunk + 4 + int(binary.BigEndian.Uint32(sealed[firstChunk:]))
+	flip := func(i int) []byte {
+		b := append([]byte(nil), sealed...)
+		b[i] ^= 1
+		return b
+	}
+	swapped := append([]byte(nil), sealed[:header]...)
+	swapped = append(swapped, sealed[firstChunk:secondChunk]...)
+	swapped = append(swapped, sealed[header:firstChunk]...)
+	swapped = append(swapped, sealed[secondChunk:]...)
+	tests := []struct {
+		name   string
+		sealed []byte
+		key    []byte
+		err    error
+	}{
+		{"cut after the header", sealed[:header], testKey, ErrBackupCorrupt},
+		{"cut inside the header", sealed[:header-2], testKey, ErrBackupCorrupt},
+		{"cut at a chunk's end", sealed[:firstChunk], testKey, ErrBackupCorrupt},
+		{"cut inside a chunk", sealed[:firstChunk+100], testKey, ErrBackupCorrupt},
+		{"cut inside the last chunk", sealed[:len(sealed)-1], testKey, ErrBackupCorrupt},
+		{"last chunk dropped", sealed[:secondChunk], testKey, ErrBackupCorrupt},
+		{"chunks swapped", swapped, testKey, ErrBackupCorrupt},
+		{"byte flipped in a chunk", flip(firstChunk - 1), testKey, ErrBackupCorrupt},
+		{"nonce prefix changed", flip(header - 1), testKey, ErrBackupCorrupt},
+		{"chunk size changed", flip(header + 1), testKey, ErrBackupCorrupt},
+		{"trailing data", append(append([]byte(nil), sealed...), 0), testKey, ErrBackupCorrupt},
+		{"another key", sealed, testOldKey, ErrBackupCorrupt},
+		{"no key", sealed, nil, ErrBackupEncrypted},
+	}
+	for _, tt := range tests {
+		if _, err := openBackup(tt.sealed, tt.key); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestPlaintextBackupPassesThrough(t *testing.T) {
+	data := []byte("a plaintext backup, as taken before the store was encrypted")
+	for _, key := range [][]byte{nil, testKey} {
+		got, err := openBackup(data, key)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("with key %x: got %q, %v, want the backup as it is", key, got, err)
+		}
+	}
+}
+
+// backupQuery saves a query to a store encrypted with the key and backs the
+// store up, returning the backup's path.
+func backupQuery(t *testing.T, path string, key []byte) string {
+	t.Helper()
+	r, err := OpenLoRepo(path, RepoOptions{EncryptionKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	q := LoQuery{AuthorID: "42", ChannelID: "7", TTL: time.Hour, Query: "+Server:Cannith kt", Created: time.Now()}
+	if err := r.Save(q); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := r.Backup(filepath.Join(path, "backups"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backup
+}
+
+func TestRestore(t *testing.T) {
+	tests := []struct {
+		name string
+		opts RepoOptions
+		err  error
+	}{
+		{"with the key", RepoOptions{EncryptionKey: testOldKey}, nil},
+		{"with the old key", RepoOptions{EncryptionKey: testKey, OldEncryptionKey: testOldKey}, nil},
+		{"without the old key", RepoOptions{EncryptionKey: testKey}, ErrBackupCorrupt},
+		{"without any key", RepoOptions{}, ErrBackupEncrypted},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		backup := backupQuery(t, filepath.Join(dir, "old"), testOldKey)
+		r, err := OpenLoRepo(filepath.Join(dir, "new"), tt.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		err = r.Restore(backup)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+		queries, ferr := r.FindByAuthor("42")
+		if ferr != nil {
+			t.Errorf("%s: %v", tt.name, ferr)
+		}
+		if restored := len(queries) == 1; restored != (tt.err == nil) {
+			t.Errorf("%s: found %d queries after restoring", tt.name, len(queries))
+		}
+		r.Close()
+	}
+}
@@ -0,0 +1,174 @@
+package lodb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+var (
+	ErrFriendsFull = errors.New("the watchlist has no room for another entry")
+)
+
+const (
+	// The maximum number of players and guilds a user can watch.
+	FRIENDMAX int = 25
+)
+
+// Friends is a user's watchlist of players and guilds. The user is notified
+// whenever a watched player leads or joins a group.
+type Friends struct {
+	UserID string
+	// Where the user is notified: the channel they last changed the
+	// watchlist from.
+	ChannelID string
+	Style     string
+	Players   []string
+	Guilds    []string
+}
+
+// Return gives where, and in what style, the user is notified.
+func (f Friends) Return() Return {
+	return Return{ChannelID: f.ChannelID, Style: f.Style, Mention: fmt.Sprintf("<@%s>", f.UserID)}
+}
+
+// OptOut marks a character as not to be watched, by a user who has verified
+// the character is theirs.
+type OptOut struct {
+	Server string
+	Name   string
+	UserID string
+}
+
+// OptOuts is the set of opted out characters, keyed by lowercase server and
+// name.
+type OptOuts map[string]bool
+
+// Has reports whether the character on the server has opted out.
+func (o OptOuts) Has(server, name string) bool {
+	return o[optOutID(server, name)]
+}
+
+// HasAny reports whether a character of the name has opted out on any
+// server.
+func (o OptOuts) HasAny(name string) bool {
+	suffix := "/" + strings.ToLower(name)
+	for id := range o {
+		if strings.HasSuffix(id, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindFriends retrieves a user's watchlist, returning an empty watchlist if
+// the user has none.
+func (r *LoRepo) FindFriends(userID string) (Friends, error) {
+	f := Friends{UserID: userID}
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(fmt.Sprintf("friends-%s", userID)))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &f)
+		})
+	})
+	return f, err
+}
+
+// SaveFriends saves the user's watchlist, deleting it once it's empty.
+func (r *LoRepo) SaveFriends(f Friends) error {
+	if len(f.Players)+len(f.Guilds) > FRIENDMAX {
+		return ErrFriendsFull
+	}
+	key := []byte(fmt.Sprintf("friends-%s", f.UserID))
+	if len(f.Players)+len(f.Guilds) == 0 {
+		return r.db.Update(func(txn *badger.Txn) error {
+			return txn.Delete(key)
+		})
+	}
+	val, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(txn *badger.Txn) error {
+		// friends-[UserID]:[Friends]
+		return txn.Set(key, val)
+	})
+}
+
+// FindAllFriends retrieves the watchlists of every user.
+func (r *LoRepo) FindAllFriends() ([]Friends, error) {
+	var lists []Friends
+	err := r.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		p := []byte("friends-")
+		for it.Seek(p); it.ValidForPrefix(p); it.Next() {
+			var f Friends
+			err := it.Item().Value(func(v []byte) error {
+				return json.Unmarshal(v, &f)
+			})
+			if err != nil {
+				return err
+			}
+			lists = append(lists, f)
+		}
+		return nil
+	})
+	return lists, err
+}
+
+// SaveOptOut adds the character to the opt-out list.
+func (r *LoRepo) SaveOptOut(o OptOut) error {
+	val, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(txn *badger.Txn) error {
+		// optout-[lowercase Server]/[lowercase Name]:[OptOut]
+		return txn.Set(optOutKey(o.Server, o.Name), val)
+	})
+}
+
+// DeleteOptOut removes the character from the opt-out list.
+func (r *LoRepo) DeleteOptOut(server, name string) error {
+	return r.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(optOutKey(server, name))
+	})
+}
+
+// FindOptOuts retrieves the opted out characters. Opt-outs from before
+// characters were verified have no server, and aren't honored.
+func (r *LoRepo) FindOptOuts() (OptOuts, error) {
+	optOuts := make(OptOuts)
+	err := r.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		p := []byte("optout-")
+		for it.Seek(p); it.ValidForPrefix(p); it.Next() {
+			id := strings.TrimPrefix(string(it.Item().Key()), "optout-")
+			if strings.Contains(id, "/") {
+				optOuts[id] = true
+			}
+		}
+		return nil
+	})
+	return optOuts, err
+}
+
+func optOutID(server, name string) string {
+	return strings.ToLower(server) + "/" + strings.ToLower(name)
+}
+
+func optOutKey(server, name string) []byte {
+	return []byte("optout-" + optOutID(server, name))
+}
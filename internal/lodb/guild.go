@@ -0,0 +1,71 @@
+package lodb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+const (
+	// Matches are sent as plain messages.
+	StyleText string = "text"
+	// Matches are sent as an embed.
+	StyleEmbed string = "embed"
+)
+
+// GuildSettings holds a guild's configuration of the bot. Empty fields fall
+// back to the bot's defaults.
+type GuildSettings struct {
+	GuildID string
+	// The prefix commands start with in the guild.
+	Prefix string
+	// The server used when a command doesn't specify one.
+	DefaultServer string
+	// The channels the bot answers in. If empty, it answers in all of them.
+	Channels []string
+	// The notification style given to new lookouts.
+	Style string
+}
+
+// Allows reports whether the bot answers commands in the channel.
+func (g GuildSettings) Allows(channelID string) bool {
+	if len(g.Channels) == 0 {
+		return true
+	}
+	for _, c := range g.Channels {
+		if c == channelID {
+			return true
+		}
+	}
+	return false
+}
+
+// FindGuildSettings retrieves the settings of a guild, returning empty
+// settings if the guild has none saved.
+func (r *LoRepo) FindGuildSettings(guildID string) (GuildSettings, error) {
+	settings := GuildSettings{GuildID: guildID}
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(fmt.Sprintf("guild-%s", guildID)))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &settings)
+		})
+	})
+	return settings, err
+}
+
+// SaveGuildSettings
+func (r *LoRepo) SaveGuildSettings(settings GuildSettings) error {
+	val, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(fmt.Sprintf("guild-%s", settings.GuildID)), val)
+	})
+}
@@ -1,9 +1,11 @@
 package lodb
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
@@ -23,8 +25,13 @@ const (
 	// The maximum value a query's user-uniqe id can be.
 	IDMAX rune = 9
 	// The maximum life-time of a query.
-	TTLMAX     time.Duration = time.Hour * 24
-	TICKPERIOD rune          = 24 * 60 * 2
+	TTLMAX time.Duration = time.Hour * 24
+	// Queries saved before timestamps were kept their tick, the half-minute
+	// of the day they were saved on, alongside their ID in the key.
+	legacyTickPeriod rune = 24 * 60 * 2
+	// The times marking evaluations is tried when commands write the same
+	// keys at once.
+	markTries int = 3
 )
 
 type LoQuery struct {
@@ -33,10 +40,94 @@ type LoQuery struct {
 	ID        rune // ID is a unique-per-user integer, 0-9.
 	TTL       time.Duration
 	Query     string // String formatted for string query in Bleve
+	Style     string // How matches are sent, StyleText or StyleEmbed.
+	Created   time.Time
+	// When the query was last matched against the groups, or zero if it
+	// hasn't been yet.
+	Evaluated time.Time
+	// The adventure packs of the character the query was filled in from,
+	// which groups for other packs are left out for; nil for any.
+	Packs []string
+}
+
+// queryValue is the value of a query entry.
+type queryValue struct {
+	Query     string
+	Packs     []string `json:",omitempty"`
+	Created   time.Time
+	Evaluated time.Time `json:",omitempty"`
+	// Slow searches in a row.
+	Strikes int `json:",omitempty"`
+}
+
+// decodeQuery parses the value of a query entry. Entries saved before
+// timestamps were kept hold only the query.
+func decodeQuery(v []byte) queryValue {
+	var val queryValue
+	if err := json.Unmarshal(v, &val); err != nil {
+		return queryValue{Query: string(v)}
+	}
+	return val
+}
+
+// isLegacyQuery reports whether the value is of an entry saved before
+// timestamps were kept.
+func isLegacyQuery(v []byte) bool {
+	var val queryValue
+	return json.Unmarshal(v, &val) != nil
+}
+
+// QueryID gives the user-unique ID of a query's final rune. Runes past IDMAX
+// are taken to be legacy, but legacy runes of the first few ticks can't be
+// told apart by the rune alone; entryID can tell by the entry's value.
+func QueryID(r rune) rune {
+	if r > IDMAX {
+		return r / legacyTickPeriod
+	}
+	return r
+}
+
+// entryID gives the user-unique ID of a query entry.
+func entryID(item *badger.Item) (rune, error) {
+	r, _ := utf8.DecodeLastRune(item.Key())
+	var legacy bool
+	err := item.Value(func(v []byte) error {
+		legacy = isLegacyQuery(v)
+		return nil
+	})
+	if legacy {
+		return r / legacyTickPeriod, err
+	}
+	return QueryID(r), err
+}
+
+// Return holds where, and in what style, a query's matches are sent.
+type Return struct {
+	ChannelID string
+	Style     string
+	Mention   string `json:",omitempty"` // Sent along with the matches.
+}
+
+// DecodeReturn parses the value of a return entry. Entries saved before
+// styles existed hold only the channel ID.
+func DecodeReturn(v []byte) Return {
+	var ret Return
+	if err := json.Unmarshal(v, &ret); err != nil {
+		return Return{ChannelID: string(v), Style: StyleText}
+	}
+	return ret
 }
 
 type LoRepo struct {
 	db *badger.DB
+	// The store's encryption key, which backups are encrypted with, if any.
+	key []byte
+	// The store's previous key, which older backups may be encrypted with.
+	oldKey []byte
+	// When maintenance last ran, for reporting.
+	maintLock  sync.Mutex
+	lastGC     time.Time
+	lastBackup string
 }
 
 func NewLoRepo(path string) (*LoRepo, error) {
@@ -81,7 +172,7 @@ func (r *LoRepo) FindByAuthor(authorid string) ([]LoQuery, error) {
 	err := r.db.View(func(txn *badger.Txn) error {
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
-		prefix := []byte(fmt.Sprintf("query-%s", authorid))
+		prefix := []byte(fmt.Sprintf("query-%s-", authorid))
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 			qItem := it.Item()
 			var valCopy []byte
@@ -100,7 +191,10 @@ func (r *LoRepo) FindByAuthor(authorid string) ([]LoQuery, error) {
 			auth := keyStr[len("query-") : len(keyStr)-len("-0")]
 
 			r, _ := utf8.DecodeLastRuneInString(keyStr)
-			id, _ := DecodeFinalRune(r)
+			id, err := entryID(qItem)
+			if err != nil {
+				return err
+			}
 			if id < IDMIN || id > IDMAX {
 				return ErrMalformedKey
 			}
@@ -112,12 +206,16 @@ func (r *LoRepo) FindByAuthor(authorid string) ([]LoQuery, error) {
 				return ErrCorruptQuery
 			}
 
+			val := decodeQuery(valCopy)
 			loQ := LoQuery{
 				AuthorID:  auth,
 				ChannelID: "",
 				ID:        r,
 				TTL:       ttl,
-				Query:     string(valCopy),
+				Query:     val.Query,
+				Created:   val.Created,
+				Evaluated: val.Evaluated,
+				Packs:     val.Packs,
 			}
 			queries = append(queries, loQ)
 		}
@@ -130,38 +228,50 @@ func (r *LoRepo) FindByAuthor(authorid string) ([]LoQuery, error) {
 }
 
 // Save // TODO: Change to return ID?
-func (r *LoRepo) Save(q LoQuery, tick rune) error {
+func (r *LoRepo) Save(q LoQuery) error {
 	// Find lowest unused index.
 	err := r.db.Update(func(txn *badger.Txn) error {
 		itOpts := badger.DefaultIteratorOptions
 		itOpts.PrefetchValues = false
 		it := txn.NewIterator(itOpts)
 		defer it.Close()
-		prefix := []byte("query-" + q.AuthorID)
-		unusedIndex := IDMIN
+		prefix := []byte("query-" + q.AuthorID + "-")
+		// Legacy keys sort after the rest, and one may hold the rune of
+		// another ID, so both the IDs and the runes in use are kept.
+		used := make(map[rune]bool)
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 			item := it.Item()
-			k := item.Key()
-			r, _ := utf8.DecodeLastRuneInString(string(k))
-			curr, _ := DecodeFinalRune(r)
-			if unusedIndex < curr {
-				break
-			} else {
-				unusedIndex = curr + 1
+			r, _ := utf8.DecodeLastRune(item.Key())
+			id, err := entryID(item)
+			if err != nil {
+				return err
 			}
+			used[id], used[r] = true, true
+		}
+		unusedIndex := IDMIN
+		for used[unusedIndex] {
+			unusedIndex++
 		}
 		if unusedIndex > IDMAX {
 			return ErrUserIndicesFull
 		}
 		// Set unused index to new query.
-		// query-[AuthorID]-[君-贤][0-9]:[Query]
-		fr := EncodeFinalRune(unusedIndex, tick)
+		// query-[AuthorID]-[0-9]:[Query]
+		fr := unusedIndex
 		qKey := fmt.Sprintf(`query-%s-%s`, q.AuthorID, string(fr))
-		e1 := badger.NewEntry([]byte(qKey), []byte(q.Query)).WithTTL(q.TTL)
+		qVal, err := json.Marshal(queryValue{Query: q.Query, Packs: q.Packs, Created: q.Created})
+		if err != nil {
+			return err
+		}
+		e1 := badger.NewEntry([]byte(qKey), qVal).WithTTL(q.TTL)
 		_ = txn.SetEntry(e1)
-		// return-[AuthorID]-[君-贤][0-9]:[ChannelID]
+		// return-[AuthorID]-[0-9]:[Return]
 		rKey := fmt.Sprintf(`return-%s-%s`, q.AuthorID, string(fr))
-		e2 := badger.NewEntry([]byte(rKey), []byte(q.ChannelID)).WithTTL(q.TTL)
+		rVal, err := json.Marshal(Return{ChannelID: q.ChannelID, Style: q.Style})
+		if err != nil {
+			return err
+		}
+		e2 := badger.NewEntry([]byte(rKey), rVal).WithTTL(q.TTL)
 		_ = txn.SetEntry(e2)
 
 		return nil
@@ -172,22 +282,144 @@ func (r *LoRepo) Save(q LoQuery, tick rune) error {
 	return nil
 }
 
-func (r *LoRepo) GetView(fn func(txn *badger.Txn) error) error {
-	return r.db.View(fn)
+// StoredQuery is a lookout query as kept in the repository, identified by
+// its author and final rune.
+type StoredQuery struct {
+	AuthorID  string
+	Rune      rune
+	Query     string
+	Packs     []string
+	Created   time.Time
+	Evaluated time.Time
+	Strikes   int
 }
 
-func EncodeFinalRune(id rune, tick rune) rune {
-	return (id * TICKPERIOD) + tick
+// IterateQueries calls fn with each stored query, stopping at the first
+// error.
+func (r *LoRepo) IterateQueries(fn func(StoredQuery) error) error {
+	return r.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte("query-")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			key := string(it.Item().Key())
+			q := StoredQuery{AuthorID: GetAuthFromKey(key), Rune: GetIDFromKey(key)}
+			err := it.Item().Value(func(v []byte) error {
+				val := decodeQuery(v)
+				q.Query, q.Packs = val.Query, val.Packs
+				q.Created, q.Evaluated, q.Strikes = val.Created, val.Evaluated, val.Strikes
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if err := fn(q); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FindReturn retrieves where, and in what style, a query's matches are sent.
+func (r *LoRepo) FindReturn(authorID string, fr rune) (Return, error) {
+	var ret Return
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(fmt.Sprintf("return-%s-%s", authorID, string(fr))))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			ret = DecodeReturn(v)
+			return nil
+		})
+	})
+	return ret, err
+}
+
+// MarkAllEvaluated records when the queries and watches were last matched
+// against the groups, along with the queries' strikes since, in as few
+// transactions as fit them. Queries and watches deleted in the meantime are
+// left deleted, and those saved in their place, with another creation time,
+// are left be. Queries keep their expiry.
+func (r *LoRepo) MarkAllEvaluated(queries []StoredQuery, watches []Watch, at time.Time) error {
+	// Marking only ever rewrites the evaluation, so it's safe to run again
+	// when a command changes one of the keys before the tick commits.
+	var err error
+	for try := 0; try < markTries; try++ {
+		err = r.markAll(queries, watches, at)
+		if err != badger.ErrConflict {
+			return err
+		}
+	}
+	return err
+}
+
+func (r *LoRepo) markAll(queries []StoredQuery, watches []Watch, at time.Time) error {
+	txn := r.db.NewTransaction(true)
+	defer func() {
+		txn.Discard()
+	}()
+	// Commit and carry on in a new transaction once one is full.
+	apply := func(fn func(txn *badger.Txn) error) error {
+		err := fn(txn)
+		if err != badger.ErrTxnTooBig {
+			return err
+		}
+		if err := txn.Commit(); err != nil {
+			return err
+		}
+		txn = r.db.NewTransaction(true)
+		return fn(txn)
+	}
+	for _, q := range queries {
+		q := q
+		if err := apply(func(txn *badger.Txn) error { return markQuery(txn, q, at) }); err != nil {
+			return err
+		}
+	}
+	for _, w := range watches {
+		w := w
+		if err := apply(func(txn *badger.Txn) error { return markWatch(txn, w, at) }); err != nil {
+			return err
+		}
+	}
+	return txn.Commit()
 }
 
-func DecodeFinalRune(r rune) (rune, rune) {
-	id := r / TICKPERIOD
-	tick := r - (id * TICKPERIOD)
-	return id, tick
+// markQuery records the query's evaluation, if it's still stored.
+func markQuery(txn *badger.Txn, q StoredQuery, at time.Time) error {
+	key := []byte(fmt.Sprintf("query-%s-%s", q.AuthorID, string(q.Rune)))
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var val queryValue
+	err = item.Value(func(v []byte) error {
+		val = decodeQuery(v)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !val.Created.Equal(q.Created) {
+		return nil
+	}
+	val.Evaluated = at
+	val.Strikes = q.Strikes
+	v, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	e := badger.NewEntry(key, v)
+	e.ExpiresAt = item.ExpiresAt()
+	return txn.SetEntry(e)
 }
 
-func NextTickRune(r rune) rune {
-	return (r + 1) % TICKPERIOD
+func (r *LoRepo) GetView(fn func(txn *badger.Txn) error) error {
+	return r.db.View(fn)
 }
 
 func GetIDFromKey(k string) rune {
@@ -0,0 +1,132 @@
+package lodb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+// StorageStats describes the store's size and contents.
+type StorageStats struct {
+	// Bytes on disk of the LSM tree and value log, as last measured by Badger.
+	LSMSize  int64
+	VLogSize int64
+	// Keys by their prefix, such as "query" or "watch".
+	Keys  map[string]int
+	Total int
+	// When value log garbage was last collected, and the last backup
+	// written, if either has happened since the store was opened.
+	LastGC     time.Time
+	LastBackup string
+}
+
+// Stats measures the store.
+func (r *LoRepo) Stats() (StorageStats, error) {
+	stats := StorageStats{Keys: make(map[string]int)}
+	stats.LSMSize, stats.VLogSize = r.db.Size()
+	err := r.db.View(func(txn *badger.Txn) error {
+		itOpts := badger.DefaultIteratorOptions
+		itOpts.PrefetchValues = false
+		it := txn.NewIterator(itOpts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := string(it.Item().Key())
+			if i := strings.Index(key, "-"); i >= 0 {
+				key = key[:i]
+			}
+			stats.Keys[key]++
+			stats.Total++
+		}
+		return nil
+	})
+	r.maintLock.Lock()
+	stats.LastGC, stats.LastBackup = r.lastGC, r.lastBackup
+	r.maintLock.Unlock()
+	return stats, err
+}
+
+// CollectGarbage rewrites value log files until none has at least the
+// discard ratio of stale data, and returns the number rewritten. Values of
+// deleted and expired keys are only reclaimed this way.
+func (r *LoRepo) CollectGarbage(discardRatio float64) (int, error) {
+	var rewrites int
+	for {
+		err := r.db.RunValueLogGC(discardRatio)
+		if errors.Is(err, badger.ErrNoRewrite) || errors.Is(err, badger.ErrRejected) {
+			break
+		} else if err != nil {
+			return rewrites, err
+		}
+		rewrites++
+	}
+	r.maintLock.Lock()
+	r.lastGC = time.Now()
+	r.maintLock.Unlock()
+	return rewrites, nil
+}
+
+// Backup writes a full backup of the store into the directory, encrypted
+// if the store is, and removes all but the newest keep backups there. It returns the backup's path.
+func (r *LoRepo) Backup(dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("badger-%s.bak", time.Now().UTC().Format("20060102-150405.000000")))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	var w io.Writer = f
+	var sealer io.WriteCloser
+	if len(r.key) > 0 {
+		if sealer, err = encryptBackup(f, r.key); err != nil {
+			f.Close()
+			os.Remove(path)
+			return "", err
+		}
+		w = sealer
+	}
+	_, err = r.db.Backup(w, 0)
+	if err == nil && sealer != nil {
+		err = sealer.Close()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	r.maintLock.Lock()
+	r.lastBackup = path
+	r.maintLock.Unlock()
+	return path, pruneBackups(dir, keep)
+}
+
+// pruneBackups removes all but the newest keep backups in the directory.
+func pruneBackups(dir string, keep int) error {
+	backups, err := filepath.Glob(filepath.Join(dir, "badger-*.bak"))
+	if err != nil {
+		return err
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+	// The timestamped names sort oldest first.
+	sort.Strings(backups)
+	for _, old := range backups[:len(backups)-keep] {
+		if err := os.Remove(old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package lodb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+var (
+	ErrUserTracksFull = errors.New("the user is tracking as many groups as they can")
+	ErrTrackNotFound  = errors.New("the user isn't tracking that group")
+)
+
+const (
+	// The maximum number of groups a user can track at once.
+	TRACKMAX int = 5
+	// How long a group is tracked for, if it doesn't fill, start or
+	// disband sooner.
+	TRACKTTL time.Duration = time.Hour * 6
+)
+
+// Track follows a single group for a user, until the group fills, starts or
+// disbands.
+type Track struct {
+	UserID    string
+	ChannelID string
+	Style     string
+	Server    string
+	GroupID   uint64
+}
+
+// Return gives where, and in what style, the user is notified.
+func (t Track) Return() Return {
+	return Return{ChannelID: t.ChannelID, Style: t.Style, Mention: fmt.Sprintf("<@%s>", t.UserID)}
+}
+
+func (t Track) key() []byte {
+	// track-[UserID]-[Server]-[GroupID]:[Track]
+	return []byte(fmt.Sprintf("track-%s-%s-%d", t.UserID, t.Server, t.GroupID))
+}
+
+// SaveTrack saves the track, expiring after TRACKTTL.
+func (r *LoRepo) SaveTrack(t Track) error {
+	val, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(txn *badger.Txn) error {
+		n := 0
+		err := iterateTracks(txn, fmt.Sprintf("track-%s-", t.UserID), func(Track) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := txn.Get(t.key()); err == badger.ErrKeyNotFound && n >= TRACKMAX {
+			return ErrUserTracksFull
+		}
+		return txn.SetEntry(badger.NewEntry(t.key(), val).WithTTL(TRACKTTL))
+	})
+}
+
+// DeleteTrack
+func (r *LoRepo) DeleteTrack(t Track) error {
+	return r.db.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(t.key()); err == badger.ErrKeyNotFound {
+			return ErrTrackNotFound
+		} else if err != nil {
+			return err
+		}
+		return txn.Delete(t.key())
+	})
+}
+
+// FindTracksByUser
+func (r *LoRepo) FindTracksByUser(userID string) ([]Track, error) {
+	var tracks []Track
+	err := r.db.View(func(txn *badger.Txn) error {
+		return iterateTracks(txn, fmt.Sprintf("track-%s-", userID), func(t Track) error {
+			tracks = append(tracks, t)
+			return nil
+		})
+	})
+	return tracks, err
+}
+
+// FindTracks retrieves the tracks of every user.
+func (r *LoRepo) FindTracks() ([]Track, error) {
+	var tracks []Track
+	err := r.db.View(func(txn *badger.Txn) error {
+		return iterateTracks(txn, "track-", func(t Track) error {
+			tracks = append(tracks, t)
+			return nil
+		})
+	})
+	return tracks, err
+}
+
+func iterateTracks(txn *badger.Txn, prefix string, fn func(Track) error) error {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	p := []byte(prefix)
+	for it.Seek(p); it.ValidForPrefix(p); it.Next() {
+		var t Track
+		err := it.Item().Value(func(v []byte) error {
+			return json.Unmarshal(v, &t)
+		})
+		if err != nil {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package lodb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+var (
+	ErrGuildWatchesFull = errors.New("the guild has no room for another watch")
+	ErrWatchNotFound    = errors.New("no watch found with that id")
+)
+
+const (
+	// The maximum number of watches a guild can have.
+	WATCHMAX int = 25
+)
+
+// Watch is a query owned by a guild rather than a user. Its matches are
+// posted to a channel for as long as it exists.
+type Watch struct {
+	GuildID   string
+	ID        int // ID is unique per guild.
+	ChannelID string
+	RoleID    string // The role mentioned with matches, if any.
+	CreatorID string
+	Query     string // String formatted for string query in Bleve
+	Style     string
+	Created   time.Time
+	// As with LoQuery's packs.
+	Packs []string `json:",omitempty"`
+	// When the watch was last matched against the groups, or zero if it
+	// hasn't been yet.
+	Evaluated time.Time `json:",omitempty"`
+}
+
+// Return gives where, and in what style, the watch's matches are sent.
+func (w Watch) Return() Return {
+	ret := Return{ChannelID: w.ChannelID, Style: w.Style}
+	if w.RoleID != "" {
+		ret.Mention = fmt.Sprintf("<@&%s>", w.RoleID)
+	}
+	return ret
+}
+
+// SaveWatch saves the watch under the lowest unused ID of its guild, and
+// returns the ID.
+func (r *LoRepo) SaveWatch(w Watch) (int, error) {
+	err := r.db.Update(func(txn *badger.Txn) error {
+		used := make(map[int]bool)
+		err := iterateWatches(txn, fmt.Sprintf("watch-%s-", w.GuildID), func(other Watch) error {
+			used[other.ID] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(used) >= WATCHMAX {
+			return ErrGuildWatchesFull
+		}
+		w.ID = 1
+		for used[w.ID] {
+			w.ID++
+		}
+		val, err := json.Marshal(w)
+		if err != nil {
+			return err
+		}
+		// watch-[GuildID]-[ID]:[Watch]
+		return txn.Set([]byte(fmt.Sprintf("watch-%s-%d", w.GuildID, w.ID)), val)
+	})
+	return w.ID, err
+}
+
+// markWatch records the watch's evaluation, if it's still stored.
+func markWatch(txn *badger.Txn, w Watch, at time.Time) error {
+	key := []byte(fmt.Sprintf("watch-%s-%d", w.GuildID, w.ID))
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var stored Watch
+	err = item.Value(func(v []byte) error {
+		return json.Unmarshal(v, &stored)
+	})
+	if err != nil {
+		return err
+	}
+	if !stored.Created.Equal(w.Created) {
+		return nil
+	}
+	stored.Evaluated = at
+	val, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return txn.Set(key, val)
+}
+
+// DeleteWatch
+func (r *LoRepo) DeleteWatch(guildID string, id int) error {
+	return r.db.Update(func(txn *badger.Txn) error {
+		key := []byte(fmt.Sprintf("watch-%s-%d", guildID, id))
+		if _, err := txn.Get(key); err == badger.ErrKeyNotFound {
+			return ErrWatchNotFound
+		} else if err != nil {
+			return err
+		}
+		return txn.Delete(key)
+	})
+}
+
+// FindWatchesByGuild
+func (r *LoRepo) FindWatchesByGuild(guildID string) ([]Watch, error) {
+	var watches []Watch
+	err := r.db.View(func(txn *badger.Txn) error {
+		return iterateWatches(txn, fmt.Sprintf("watch-%s-", guildID), func(w Watch) error {
+			watches = append(watches, w)
+			return nil
+		})
+	})
+	return watches, err
+}
+
+// FindWatches retrieves the watches of every guild.
+func (r *LoRepo) FindWatches() ([]Watch, error) {
+	var watches []Watch
+	err := r.db.View(func(txn *badger.Txn) error {
+		return iterateWatches(txn, "watch-", func(w Watch) error {
+			watches = append(watches, w)
+			return nil
+		})
+	})
+	return watches, err
+}
+
+func iterateWatches(txn *badger.Txn, prefix string, fn func(Watch) error) error {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	p := []byte(prefix)
+	for it.Seek(p); it.ValidForPrefix(p); it.Next() {
+		var w Watch
+		err := it.Item().Value(func(v []byte) error {
+			return json.Unmarshal(v, &w)
+		})
+		if err != nil {
+			return err
+		}
+		if err := fn(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
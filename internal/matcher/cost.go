@@ -0,0 +1,124 @@
+package matcher
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// Cost is a rough measure of how expensive a query is to search, taken from
+// its parts before it's ever run.
+type Cost struct {
+	// Words searched for, across terms and phrases.
+	Terms int
+	// Wildcard and prefix terms, which are matched against every term of
+	// their field.
+	Wildcards int
+	// Terms searched with an edit distance.
+	Fuzzy   int
+	Regexps int
+	// How deeply the query's clauses nest.
+	Depth int
+}
+
+// Limits caps the cost of a query. Limits left out, or negative, fall back
+// to those of DefaultLimits; a limit of 0 forbids that part of a query, such
+// as regular expressions, outright.
+type Limits struct {
+	Terms     *int `json:"Terms,omitempty"`
+	Wildcards *int `json:"Wildcards,omitempty"`
+	Fuzzy     *int `json:"Fuzzy,omitempty"`
+	Regexps   *int `json:"Regexps,omitempty"`
+	Depth     *int `json:"Depth,omitempty"`
+}
+
+var DefaultLimits = Limits{
+	Terms:     Limit(24),
+	Wildcards: Limit(2),
+	Fuzzy:     Limit(2),
+	Regexps:   Limit(1),
+	Depth:     Limit(4),
+}
+
+// Limit gives a limit of n, for filling in Limits.
+func Limit(n int) *int {
+	return &n
+}
+
+// QueryCost parses the query string and measures its cost.
+func QueryCost(q string) (Cost, error) {
+	var c Cost
+	parsed, err := bleve.NewQueryStringQuery(q).Parse()
+	if err != nil {
+		return c, err
+	}
+	c.add(parsed, 1)
+	return c, nil
+}
+
+func (c *Cost) add(q query.Query, depth int) {
+	if depth > c.Depth {
+		c.Depth = depth
+	}
+	switch q := q.(type) {
+	case *query.BooleanQuery:
+		for _, sub := range []query.Query{q.Must, q.Should, q.MustNot} {
+			if sub != nil {
+				c.add(sub, depth+1)
+			}
+		}
+	case *query.ConjunctionQuery:
+		for _, sub := range q.Conjuncts {
+			c.add(sub, depth+1)
+		}
+	case *query.DisjunctionQuery:
+		for _, sub := range q.Disjuncts {
+			c.add(sub, depth+1)
+		}
+	case *query.MatchQuery:
+		c.Terms += len(strings.Fields(q.Match))
+		if q.Fuzziness > 0 {
+			c.Fuzzy++
+		}
+	case *query.MatchPhraseQuery:
+		c.Terms += len(strings.Fields(q.MatchPhrase))
+	case *query.FuzzyQuery:
+		c.Terms++
+		c.Fuzzy++
+	case *query.WildcardQuery, *query.PrefixQuery:
+		c.Terms++
+		c.Wildcards++
+	case *query.RegexpQuery:
+		c.Terms++
+		c.Regexps++
+	default:
+		c.Terms++
+	}
+}
+
+// Check reports the first of the limits which the cost exceeds, if any.
+func (c Cost) Check(l Limits) error {
+	checks := []struct {
+		name       string
+		cost       int
+		most, dflt *int
+	}{
+		{"search terms", c.Terms, l.Terms, DefaultLimits.Terms},
+		{"wildcard terms", c.Wildcards, l.Wildcards, DefaultLimits.Wildcards},
+		{"fuzzy terms", c.Fuzzy, l.Fuzzy, DefaultLimits.Fuzzy},
+		{"regular expressions", c.Regexps, l.Regexps, DefaultLimits.Regexps},
+		{"levels of nesting", c.Depth, l.Depth, DefaultLimits.Depth},
+	}
+	for _, check := range checks {
+		most := *check.dflt
+		if check.most != nil && *check.most >= 0 {
+			most = *check.most
+		}
+		if check.cost > most {
+			return fmt.Errorf("it has %d %s, and at most %d are allowed", check.cost, check.name, most)
+		}
+	}
+	return nil
+}
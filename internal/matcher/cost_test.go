@@ -0,0 +1,67 @@
+package matcher
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQueryCost(t *testing.T) {
+	tests := []struct {
+		query string
+		want  Cost
+	}{
+		{"kt", Cost{Terms: 1, Depth: 3}},
+		{"+Server:Cannith kt heals", Cost{Terms: 3, Depth: 3}},
+		{`"need heals" kt`, Cost{Terms: 3, Depth: 3}},
+		{"-Full:yes +Group.MinimumLevel:<=20", Cost{Terms: 2, Depth: 3}},
+		{"kt~1", Cost{Terms: 1, Fuzzy: 1, Depth: 3}},
+		{"k*", Cost{Terms: 1, Wildcards: 1, Depth: 3}},
+		{"Comment:/k.t/", Cost{Terms: 1, Regexps: 1, Depth: 3}},
+		{"Quest:kt~2 vod~1 t?d", Cost{Terms: 3, Wildcards: 1, Fuzzy: 2, Depth: 3}},
+	}
+	for _, tt := range tests {
+		got, err := QueryCost(tt.query)
+		if err != nil {
+			t.Errorf("QueryCost(%q) failed: %v", tt.query, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("QueryCost(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+	if _, err := QueryCost("Level:>=x"); err == nil {
+		t.Error("QueryCost of an unparseable query didn't fail")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	fuzzy := Cost{Terms: 2, Fuzzy: 1, Depth: 3}
+	tests := []struct {
+		name   string
+		cost   Cost
+		limits Limits
+		// A part of the error, or empty if the cost is within the limits.
+		err string
+	}{
+		{"unset limits use the defaults", fuzzy, Limits{}, ""},
+		{"defaults still apply", Cost{Terms: 25, Depth: 3}, Limits{}, "it has 25 search terms, and at most 24 are allowed"},
+		{"zero forbids", fuzzy, Limits{Fuzzy: Limit(0)}, "it has 1 fuzzy terms, and at most 0 are allowed"},
+		{"zero allows none of it", Cost{Terms: 1, Depth: 3}, Limits{Fuzzy: Limit(0), Regexps: Limit(0)}, ""},
+		{"negative uses the default", Cost{Regexps: 1, Terms: 1, Depth: 3}, Limits{Regexps: Limit(-1)}, ""},
+		{"negative uses the default when exceeded", Cost{Regexps: 2, Terms: 2, Depth: 3}, Limits{Regexps: Limit(-1)}, "2 regular expressions"},
+		{"raised", Cost{Terms: 30, Depth: 3}, Limits{Terms: Limit(40)}, ""},
+		{"lowered", Cost{Terms: 3, Depth: 3}, Limits{Terms: Limit(2)}, "3 search terms"},
+		{"wildcards", Cost{Terms: 3, Wildcards: 3, Depth: 3}, Limits{}, "3 wildcard terms"},
+		{"depth", Cost{Terms: 1, Depth: 3}, Limits{Depth: Limit(2)}, "3 levels of nesting"},
+		{"first exceeded is reported", Cost{Terms: 30, Wildcards: 5, Depth: 3}, Limits{}, "search terms"},
+	}
+	for _, tt := range tests {
+		err := tt.cost.Check(tt.limits)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: got %v, want no error", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.err)
+		}
+	}
+}
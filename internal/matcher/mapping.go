@@ -1,4 +1,4 @@
-package main
+package matcher
 
 import (
 	"github.com/blevesearch/bleve/v2"
@@ -9,10 +9,23 @@ import (
 )
 
 func buildIndexMapping() (mapping.IndexMapping, error) {
+	indexMapping := bleve.NewIndexMapping()
+	ddoIndex, ddoSearch := ddoAnalyzers()
+	if err := indexMapping.AddCustomAnalyzer(ddoAnalyzerName, ddoIndex); err != nil {
+		return nil, err
+	}
+	if err := indexMapping.AddCustomAnalyzer(ddoSearchAnalyzerName, ddoSearch); err != nil {
+		return nil, err
+	}
+
 	// a generic reusable mapping for english text
 	englishTextFieldMapping := bleve.NewTextFieldMapping()
 	englishTextFieldMapping.Analyzer = en.AnalyzerName
 
+	// a mapping for text full of DDO abbreviations
+	ddoTextFieldMapping := bleve.NewTextFieldMapping()
+	ddoTextFieldMapping.Analyzer = ddoAnalyzerName
+
 	// a generic reusable mapping for keyword text
 	keywordFieldMapping := bleve.NewTextFieldMapping()
 	keywordFieldMapping.Analyzer = keyword.Name
@@ -21,8 +34,19 @@ func buildIndexMapping() (mapping.IndexMapping, error) {
 	simpleFieldMapping := bleve.NewTextFieldMapping()
 	simpleFieldMapping.Analyzer = simple.Name
 
-	// a generic reusable mapping for booleans
-	booleanFieldMapping := bleve.NewBooleanFieldMapping()
+	// a mapping for derived values, such as yes or no, which unfielded
+	// searches shouldn't match
+	derivedFieldMapping := bleve.NewTextFieldMapping()
+	derivedFieldMapping.Analyzer = simple.Name
+	derivedFieldMapping.IncludeInAll = false
+
+	// a mapping for derived values kept whole, such as pack names
+	derivedKeywordFieldMapping := bleve.NewTextFieldMapping()
+	derivedKeywordFieldMapping.Analyzer = keyword.Name
+	derivedKeywordFieldMapping.IncludeInAll = false
+
+	// a generic reusable mapping for times
+	dateTimeFieldMapping := bleve.NewDateTimeFieldMapping()
 
 	// a generic reusable mapping for numbers
 	numericFieldMapping := bleve.NewNumericFieldMapping()
@@ -33,14 +57,34 @@ func buildIndexMapping() (mapping.IndexMapping, error) {
 	sGroupMapping.AddFieldMappingsAt("Server", keywordFieldMapping)
 	//  Members
 	sGroupMapping.AddFieldMappingsAt("Members", numericFieldMapping)
-	//  Fresh
-	sGroupMapping.AddFieldMappingsAt("Fresh", booleanFieldMapping)
+	//  Changed
+	sGroupMapping.AddFieldMappingsAt("Changed", dateTimeFieldMapping)
+	//  Quest
+	sGroupMapping.AddFieldMappingsAt("Quest", ddoTextFieldMapping)
+	//  QuestLevel
+	sGroupMapping.AddFieldMappingsAt("QuestLevel", numericFieldMapping)
+	//  Pack
+	sGroupMapping.AddFieldMappingsAt("Pack", englishTextFieldMapping)
+	//  Patron
+	sGroupMapping.AddFieldMappingsAt("Patron", englishTextFieldMapping)
+	//  Tier
+	sGroupMapping.AddFieldMappingsAt("Tier", derivedFieldMapping)
+	//  Raid
+	sGroupMapping.AddFieldMappingsAt("Raid", derivedFieldMapping)
+	//  Capacity
+	sGroupMapping.AddFieldMappingsAt("Capacity", numericFieldMapping)
+	//  OpenSlots
+	sGroupMapping.AddFieldMappingsAt("Slots", numericFieldMapping)
+	//  Full
+	sGroupMapping.AddFieldMappingsAt("Full", derivedFieldMapping)
+	//  PackKey
+	sGroupMapping.AddFieldMappingsAt("PackKey", derivedKeywordFieldMapping)
 	//  Group
 	groupMapping := bleve.NewDocumentMapping()
 	//    Id
 	groupMapping.AddFieldMappingsAt("Id", keywordFieldMapping)
 	//    Comment
-	groupMapping.AddFieldMappingsAt("Comment", englishTextFieldMapping)
+	groupMapping.AddFieldMappingsAt("Comment", ddoTextFieldMapping)
 	//    Difficulty
 	groupMapping.AddFieldMappingsAt("Difficulty", simpleFieldMapping)
 	//    MinLevel
@@ -57,7 +101,15 @@ func buildIndexMapping() (mapping.IndexMapping, error) {
 	//      Location
 	//        Name
 	//        Region
+	//      Name
+	//      Guild
 	memberMapping := bleve.NewDocumentMapping()
+	// Players are watched by name, not searched for.
+	unindexedFieldMapping := bleve.NewTextFieldMapping()
+	unindexedFieldMapping.Index = false
+	unindexedFieldMapping.IncludeInAll = false
+	memberMapping.AddFieldMappingsAt("Name", unindexedFieldMapping)
+	memberMapping.AddFieldMappingsAt("Guild", unindexedFieldMapping)
 	locationMapping := bleve.NewDocumentMapping()
 	locationMapping.AddFieldMappingsAt("Name", englishTextFieldMapping)
 	locationMapping.AddFieldMappingsAt("Region", englishTextFieldMapping)
@@ -69,7 +121,7 @@ func buildIndexMapping() (mapping.IndexMapping, error) {
 	//    Quest
 	questMapping := bleve.NewDocumentMapping()
 	//      Name
-	questMapping.AddFieldMappingsAt("Name", englishTextFieldMapping)
+	questMapping.AddFieldMappingsAt("Name", ddoTextFieldMapping)
 	//      AdventurePack
 	questMapping.AddFieldMappingsAt("RequiredAdventurePack", englishTextFieldMapping)
 	//      AdventureArea
@@ -85,7 +137,6 @@ func buildIndexMapping() (mapping.IndexMapping, error) {
 
 	sGroupMapping.AddSubDocumentMapping("Group", groupMapping)
 
-	indexMapping := bleve.NewIndexMapping()
 	indexMapping.AddDocumentMapping("SearchableGroup", sGroupMapping)
 
 	indexMapping.TypeField = "type"
@@ -0,0 +1,215 @@
+package matcher
+
+import (
+	"lfm_lookout/internal/audit"
+	"lfm_lookout/internal/quests"
+
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// SearchableGroup is a group along with the fields derived for searching it.
+// The quest fields are filled in from the quest catalog, by the group's quest
+// or else a quest named in its comment, and are empty if neither is known.
+type SearchableGroup struct {
+	Server  string
+	Group   audit.Group
+	Members int
+	// When the group was first seen, or last seen to change.
+	Changed time.Time
+	// The canonical name of the group's quest.
+	Quest      string
+	QuestLevel int
+	Pack       string
+	Patron     string
+	// The tier of the quest, or of the group's levels when higher.
+	Tier string
+	// "yes" or "no", so as to be searched as Raid:yes.
+	Raid string
+	// The seats of the group, and how many are open. Groups without a quest
+	// are taken to be parties, unless they have too many members.
+	Capacity  int
+	OpenSlots int `json:"Slots"`
+	// "yes" or "no", as with Raid.
+	Full string
+	// The pack lowercased, or freeContent for groups without one, so as to
+	// filter by the packs a character owns.
+	PackKey string
+}
+
+const (
+	partyCapacity int = 6
+	raidCapacity  int = 12
+	// The pack key of groups for quests which need no pack.
+	freeContent string = "free"
+)
+
+// NewSearchableGroup derives the searchable fields of the server's group,
+// last changed at the given time.
+func NewSearchableGroup(server string, group audit.Group, changed time.Time) SearchableGroup {
+	g := SearchableGroup{
+		Server:  server,
+		Group:   group,
+		Members: 1 + len(group.Members),
+		Changed: changed,
+		Quest:   group.Quest.Name,
+		Pack:    group.Quest.AdventurePack,
+		Patron:  group.Quest.Patron,
+		Tier:    quests.TierOf(group.MinLevel),
+	}
+	raid := group.Quest.GroupSize == "Raid"
+	q, ok := quests.Lookup(group.Quest.Name)
+	if !ok && group.Quest.Name == "" {
+		q, ok = quests.InText(group.Comment)
+	}
+	if ok {
+		g.Quest = q.Name
+		g.QuestLevel = q.Level
+		g.Tier = quests.HigherTier(q.Tier, g.Tier)
+		raid = raid || q.Raid
+		if g.Pack == "" {
+			g.Pack = q.Pack
+		}
+		if g.Patron == "" {
+			g.Patron = q.Patron
+		}
+	}
+	g.Raid = "no"
+	g.Capacity = partyCapacity
+	if raid || g.Members > partyCapacity {
+		g.Raid = "yes"
+		g.Capacity = raidCapacity
+	}
+	g.OpenSlots = g.Capacity - g.Members
+	if g.OpenSlots < 0 {
+		g.OpenSlots = 0
+	}
+	g.Full = "no"
+	if g.OpenSlots == 0 {
+		g.Full = "yes"
+	}
+	g.PackKey = packKey(g.Pack)
+	return g
+}
+
+func packKey(pack string) string {
+	if pack == "" {
+		return freeContent
+	}
+	return strings.ToLower(pack)
+}
+
+// String formats the group with its members out of its capacity.
+func (g SearchableGroup) String() string {
+	return g.Group.Format(g.Capacity)
+}
+
+// IsRaid reports whether the group is for a raid.
+func (g SearchableGroup) IsRaid() bool {
+	return g.Raid == "yes"
+}
+
+// IsFull reports whether the group has no open slots.
+func (g SearchableGroup) IsFull() bool {
+	return g.Full == "yes"
+}
+
+// Key identifies the group across all servers.
+func (g SearchableGroup) Key() string {
+	return fmt.Sprintf("%s/%d", g.Server, g.Group.Id)
+}
+
+// Index is an in-memory index of groups, which lookout queries are matched
+// against.
+type Index struct {
+	index  bleve.Index
+	groups map[string]SearchableGroup
+}
+
+// NewIndex indexes the groups.
+func NewIndex(groups []SearchableGroup) (*Index, error) {
+	mapping, err := buildIndexMapping()
+	if err != nil {
+		return nil, err
+	}
+	index, err := bleve.NewMemOnly(mapping)
+	if err != nil {
+		return nil, err
+	}
+	i := &Index{index: index, groups: make(map[string]SearchableGroup, len(groups))}
+	batch := index.NewBatch()
+	for _, g := range groups {
+		key := g.Key()
+		i.groups[key] = g
+		batch.Index(key, g)
+	}
+	if err := index.Batch(batch); err != nil {
+		index.Close()
+		return nil, err
+	}
+	return i, nil
+}
+
+// Close releases the in-memory index. The index must not be matched against
+// afterwards, so an index shared with other goroutines is only closed once
+// they are done with it.
+func (i *Index) Close() error {
+	return i.index.Close()
+}
+
+// Filter narrows the groups matched, beyond the query.
+type Filter struct {
+	// Only groups changed after this time, unless it's zero.
+	Since time.Time
+	// Only groups with open slots.
+	Open bool
+	// Only groups for free content or one of these adventure packs, unless
+	// it's nil.
+	Packs []string
+}
+
+// Match runs the query string against the index, and returns up to size of
+// the matched groups which pass the filter.
+func (i *Index) Match(q string, filter Filter, size int) ([]SearchableGroup, error) {
+	queryBase, err := bleve.NewQueryStringQuery(q).Parse()
+	if err != nil {
+		return nil, err
+	}
+	setSearchAnalyzers(queryBase, i.index.Mapping().AnalyzerNameForPath)
+	conjuncts := []query.Query{queryBase}
+	if !filter.Since.IsZero() {
+		after := false
+		changed := bleve.NewDateRangeInclusiveQuery(filter.Since, time.Time{}, &after, nil)
+		changed.SetField("Changed")
+		conjuncts = append(conjuncts, changed)
+	}
+	if filter.Open {
+		open := bleve.NewMatchQuery("no")
+		open.SetField("Full")
+		conjuncts = append(conjuncts, open)
+	}
+	if filter.Packs != nil {
+		owned := bleve.NewDisjunctionQuery()
+		for _, pack := range append([]string{""}, filter.Packs...) {
+			term := bleve.NewTermQuery(packKey(pack))
+			term.SetField("PackKey")
+			owned.AddQuery(term)
+		}
+		conjuncts = append(conjuncts, owned)
+	}
+	search := bleve.NewSearchRequest(bleve.NewConjunctionQuery(conjuncts...))
+	search.Size = size
+	searchResults, err := i.index.Search(search)
+	if err != nil {
+		return nil, err
+	}
+	matches := make([]SearchableGroup, 0, len(searchResults.Hits))
+	for _, hit := range searchResults.Hits {
+		matches = append(matches, i.groups[hit.ID])
+	}
+	return matches, nil
+}
@@ -0,0 +1,193 @@
+package matcher
+
+import (
+	"lfm_lookout/internal/quests"
+
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/blevesearch/bleve/v2/analysis"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/v2/analysis/token/porter"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/v2/registry"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+const (
+	synonymFilterName = "ddo_synonyms"
+	// Groups are indexed with abbreviations expanded, while queries are
+	// analyzed without, so that an abbreviation in a query matches only
+	// itself, but the full words also match the abbreviation.
+	ddoAnalyzerName       = "ddo"
+	ddoSearchAnalyzerName = "ddo_search"
+)
+
+//go:embed synonyms.txt
+var defaultSynonyms string
+
+var (
+	synonymsLock sync.RWMutex
+	// The words each abbreviation expands to.
+	synonyms map[string][]string
+)
+
+func init() {
+	dict, err := parseSynonyms(strings.NewReader(defaultSynonyms))
+	if err != nil {
+		panic(err)
+	}
+	synonyms = withQuestAliases(dict)
+	registry.RegisterTokenFilter(synonymFilterName, synonymFilterConstructor)
+}
+
+// LoadSynonyms replaces the abbreviation dictionary with the file at the
+// path, for indexes made afterwards.
+func LoadSynonyms(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dict, err := parseSynonyms(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	synonymsLock.Lock()
+	synonyms = withQuestAliases(dict)
+	synonymsLock.Unlock()
+	return nil
+}
+
+func parseSynonyms(r io.Reader) (map[string][]string, error) {
+	dict := make(map[string][]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		split := strings.SplitN(line, "=", 2)
+		words := strings.Fields(strings.ToLower(split[len(split)-1]))
+		if len(split) != 2 || len(words) == 0 {
+			return nil, fmt.Errorf("line %d: expected abbreviations = words", n)
+		}
+		for _, abbr := range strings.Split(split[0], ",") {
+			abbr = strings.ToLower(strings.TrimSpace(abbr))
+			if abbr == "" || strings.ContainsAny(abbr, " \t") {
+				return nil, fmt.Errorf("line %d: abbreviations must be single words", n)
+			}
+			dict[abbr] = words
+		}
+	}
+	return dict, scanner.Err()
+}
+
+// withQuestAliases adds the single-word aliases of catalog quests to the
+// dictionary, without overriding its own entries.
+func withQuestAliases(dict map[string][]string) map[string][]string {
+	for _, q := range quests.All() {
+		for _, alias := range q.Aliases {
+			alias = strings.ToLower(alias)
+			if _, ok := dict[alias]; ok || strings.ContainsAny(alias, " ") {
+				continue
+			}
+			dict[alias] = strings.Fields(strings.ToLower(q.Name))
+		}
+	}
+	return dict
+}
+
+// synonymFilter follows each abbreviation with the words it expands to, the
+// first in the abbreviation's position, and the rest in the positions after,
+// so that phrases of the words match the abbreviation.
+type synonymFilter struct {
+	synonyms map[string][]string
+}
+
+func synonymFilterConstructor(config map[string]interface{}, cache *registry.Cache) (analysis.TokenFilter, error) {
+	synonymsLock.RLock()
+	defer synonymsLock.RUnlock()
+	return &synonymFilter{synonyms: synonyms}, nil
+}
+
+func (f *synonymFilter) Filter(input analysis.TokenStream) analysis.TokenStream {
+	output := make(analysis.TokenStream, 0, len(input))
+	for _, token := range input {
+		output = append(output, token)
+		for i, word := range f.synonyms[string(token.Term)] {
+			output = append(output, &analysis.Token{
+				Term:     []byte(word),
+				Start:    token.Start,
+				End:      token.End,
+				Position: token.Position + i,
+				Type:     token.Type,
+			})
+		}
+	}
+	return output
+}
+
+// ddoAnalyzers returns the configurations of the analyzers for indexing and
+// searching text full of abbreviations. Both are the en analyzer, with the
+// index analyzer expanding abbreviations before stop words and stemming.
+func ddoAnalyzers() (index, search map[string]interface{}) {
+	index = map[string]interface{}{
+		"type":      custom.Name,
+		"tokenizer": unicode.Name,
+		"token_filters": []string{
+			en.PossessiveName,
+			lowercase.Name,
+			synonymFilterName,
+			en.StopName,
+			porter.Name,
+		},
+	}
+	search = map[string]interface{}{
+		"type":      custom.Name,
+		"tokenizer": unicode.Name,
+		"token_filters": []string{
+			en.PossessiveName,
+			lowercase.Name,
+			en.StopName,
+			porter.Name,
+		},
+	}
+	return index, search
+}
+
+// setSearchAnalyzers sets the matches and phrases of the query, on fields
+// indexed with the ddo analyzer, to be analyzed with the ddo search analyzer.
+func setSearchAnalyzers(q query.Query, analyzerForField func(string) string) {
+	switch q := q.(type) {
+	case *query.BooleanQuery:
+		for _, sub := range []query.Query{q.Must, q.Should, q.MustNot} {
+			if sub != nil {
+				setSearchAnalyzers(sub, analyzerForField)
+			}
+		}
+	case *query.ConjunctionQuery:
+		for _, sub := range q.Conjuncts {
+			setSearchAnalyzers(sub, analyzerForField)
+		}
+	case *query.DisjunctionQuery:
+		for _, sub := range q.Disjuncts {
+			setSearchAnalyzers(sub, analyzerForField)
+		}
+	case *query.MatchQuery:
+		if analyzerForField(q.FieldVal) == ddoAnalyzerName {
+			q.Analyzer = ddoSearchAnalyzerName
+		}
+	case *query.MatchPhraseQuery:
+		if analyzerForField(q.FieldVal) == ddoAnalyzerName {
+			q.Analyzer = ddoSearchAnalyzerName
+		}
+	}
+}
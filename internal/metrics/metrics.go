@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The namespace every metric is named under.
+const namespace = "lookout"
+
+// The results notifications are counted by.
+const (
+	Sent    = "sent"
+	Failed  = "failed"
+	Retried = "retried"
+)
+
+var (
+	AuditFetch = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "audit_fetch_seconds",
+		Help:      "How long fetching the audit took, including failed fetches.",
+		Buckets:   prometheus.ExponentialBuckets(0.125, 2, 8),
+	})
+	AuditFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audit_fetch_failures_total",
+		Help:      "The number of times fetching the audit failed.",
+	})
+	AuditAge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "audit_age_seconds",
+		Help:      "How old the groups being matched against are.",
+	})
+	Groups = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "groups",
+		Help:      "The number of groups listed, by server.",
+	}, []string{"server"})
+	IndexBuild = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "index_build_seconds",
+		Help:      "How long indexing a new audit's groups took.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 10),
+	})
+	Queries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queries",
+		Help:      "The number of lookouts matched in the last tick.",
+	})
+	Watches = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "watches",
+		Help:      "The number of watches matched in the last tick.",
+	})
+	QueryMatch = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "query_match_seconds",
+		Help:      "How long searching with a single lookout took.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 10),
+	})
+	Notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_total",
+		Help:      "The number of notifications sent, failed and retried.",
+	}, []string{"result"})
+	Commands = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_total",
+		Help:      "The number of commands invoked, by name.",
+	}, []string{"command"})
+	Guilds = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "guilds",
+		Help:      "The number of servers the bot is in.",
+	})
+)
+
+// Handler serves the metrics to Prometheus.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
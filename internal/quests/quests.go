@@ -0,0 +1,161 @@
+// Package quests is a catalog of DDO quests, for filling in what the
+// PlayerAudit data leaves out.
+package quests
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	TierHeroic    string = "heroic"
+	TierEpic      string = "epic"
+	TierLegendary string = "legendary"
+)
+
+//go:embed quests.json
+var data []byte
+
+// Quest is a catalog entry. Level is the quest's base level, within its tier.
+type Quest struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+	Level   int      `json:"level"`
+	Tier    string   `json:"tier"`
+	Pack    string   `json:"pack"`
+	Patron  string   `json:"patron"`
+	Raid    bool     `json:"raid"`
+}
+
+var (
+	catalog []Quest
+	// Quests by their normalized names and aliases.
+	byName = make(map[string]int)
+	// Quests by the names and aliases distinctive enough to find in text.
+	inText = make(map[string]int)
+)
+
+func init() {
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		panic(err)
+	}
+	for i, q := range catalog {
+		add := func(name string, distinctive bool) {
+			key := normalize(name)
+			if j, ok := byName[key]; ok && j != i {
+				panic(fmt.Sprintf("quests: %q names both %s and %s", name, catalog[j].Name, q.Name))
+			}
+			byName[key] = i
+			if distinctive {
+				inText[key] = i
+			}
+		}
+		add(q.Name, isDistinctive(q.Name))
+		if strings.HasPrefix(strings.ToLower(q.Name), "the ") {
+			trimmed := q.Name[len("the "):]
+			add(trimmed, isDistinctive(trimmed))
+		}
+		for _, a := range q.Aliases {
+			add(a, isDistinctive(a))
+		}
+	}
+}
+
+// isDistinctive reports whether a name is unlikely to turn up in text other
+// than as the quest's name: a phrase of several words, or an acronym such as
+// "VoD". Single words, such as "Shroud", are too often ordinary words.
+func isDistinctive(name string) bool {
+	if len(strings.Fields(normalize(name))) > 1 {
+		return true
+	}
+	capitals := 0
+	for _, r := range name {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+		if unicode.IsUpper(r) {
+			capitals++
+		}
+	}
+	return capitals >= 2
+}
+
+// All returns every quest in the catalog.
+func All() []Quest {
+	return append([]Quest(nil), catalog...)
+}
+
+// Lookup finds a quest by its name or an alias, ignoring case and punctuation.
+func Lookup(name string) (Quest, bool) {
+	i, ok := byName[normalize(name)]
+	if !ok {
+		return Quest{}, false
+	}
+	return catalog[i], true
+}
+
+// InText finds the quest named in a piece of text, such as a group's comment,
+// by any of its distinctive names or aliases as whole words. If no quest, or
+// more than one, is named, it is not found.
+func InText(s string) (Quest, bool) {
+	text := " " + normalize(s) + " "
+	found := -1
+	for name, i := range inText {
+		if strings.Contains(text, " "+name+" ") {
+			if found != -1 && found != i {
+				return Quest{}, false
+			}
+			found = i
+		}
+	}
+	if found == -1 {
+		return Quest{}, false
+	}
+	return catalog[found], true
+}
+
+// TierOf returns the tier of the character level.
+func TierOf(level int) string {
+	switch {
+	case level >= 30:
+		return TierLegendary
+	case level >= 20:
+		return TierEpic
+	default:
+		return TierHeroic
+	}
+}
+
+// HigherTier returns whichever of the tiers is higher.
+func HigherTier(a, b string) string {
+	if tierRank(b) > tierRank(a) {
+		return b
+	}
+	return a
+}
+
+func tierRank(tier string) int {
+	switch tier {
+	case TierLegendary:
+		return 2
+	case TierEpic:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// normalize lowercases the words of s, dropping punctuation, and joins them
+// with single spaces.
+func normalize(s string) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+	for i, w := range words {
+		words[i] = strings.Trim(w, "'")
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,117 @@
+package quests
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Killing Time", "Killing Time"},
+		{"killing   time!", "Killing Time"},
+		{"KT", "Killing Time"},
+		{"kt", "Killing Time"},
+		{"The Shroud", "The Shroud"},
+		{"Shroud", "The Shroud"},
+		{"shroud", "The Shroud"},
+		{"Baba's Hut", "Old Baba's Hut"},
+		{"'OBH'", "Old Baba's Hut"},
+		{"Titan Awakes", "The Titan Awakes"},
+		{"Chrono", "The Chronoscope"},
+		{"Tomb of the Tormented", "Tomb of the Tormented"},
+		{"Some Quest Not in the Catalog", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		q, ok := Lookup(tt.name)
+		if ok != (tt.want != "") || q.Name != tt.want {
+			t.Errorf("Lookup(%q) = %q, %v, want %q", tt.name, q.Name, ok, tt.want)
+		}
+	}
+}
+
+func TestInText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"KT R5 need heals", "Killing Time"},
+		{"lfm kt, all welcome", "Killing Time"},
+		{"VoD elite farm", "Vision of Destruction"},
+		{"LFM Tomb of the Tormented normal", "Tomb of the Tormented"},
+		{"old baba's hut r1", "Old Baba's Hut"},
+		// Single words are too often ordinary words to go by.
+		{"lfm shroud", ""},
+		{"need a tank for the chrono", ""},
+		// Only whole words count.
+		{"ktr farm", ""},
+		// More than one quest is no quest.
+		{"VoD then ToD", ""},
+		// The same quest twice is still the one quest.
+		{"KT (Killing Time) R10", "Killing Time"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		q, ok := InText(tt.text)
+		if ok != (tt.want != "") || q.Name != tt.want {
+			t.Errorf("InText(%q) = %q, %v, want %q", tt.text, q.Name, ok, tt.want)
+		}
+	}
+}
+
+func TestQuestFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		level int
+		tier  string
+		pack  string
+		raid  bool
+	}{
+		{"Killing Time", 30, TierLegendary, "Vecna Unleashed", true},
+		{"Vision of Destruction", 20, TierEpic, "Vale of Twilight", true},
+		{"Gianthold Tor", 12, TierHeroic, "Gianthold", false},
+		{"The Shroud", 16, TierHeroic, "", true},
+	}
+	for _, tt := range tests {
+		q, ok := Lookup(tt.name)
+		if !ok {
+			t.Errorf("%s missing from the catalog", tt.name)
+			continue
+		}
+		if q.Level != tt.level || q.Tier != tt.tier || q.Pack != tt.pack || q.Raid != tt.raid {
+			t.Errorf("%s is %+v, want level %d, tier %s, pack %q, raid %v", tt.name, q, tt.level, tt.tier, tt.pack, tt.raid)
+		}
+	}
+}
+
+func TestTiers(t *testing.T) {
+	tests := []struct {
+		level int
+		tier  string
+	}{
+		{1, TierHeroic},
+		{19, TierHeroic},
+		{20, TierEpic},
+		{29, TierEpic},
+		{30, TierLegendary},
+		{34, TierLegendary},
+	}
+	for _, tt := range tests {
+		if got := TierOf(tt.level); got != tt.tier {
+			t.Errorf("TierOf(%d) = %s, want %s", tt.level, got, tt.tier)
+		}
+	}
+	higher := []struct {
+		a, b, want string
+	}{
+		{TierHeroic, TierEpic, TierEpic},
+		{TierLegendary, TierEpic, TierLegendary},
+		{TierEpic, TierEpic, TierEpic},
+		{"", TierEpic, TierEpic},
+	}
+	for _, tt := range higher {
+		if got := HigherTier(tt.a, tt.b); got != tt.want {
+			t.Errorf("HigherTier(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
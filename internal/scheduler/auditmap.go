@@ -0,0 +1,34 @@
+package scheduler
+
+import (
+	"lfm_lookout/internal/audit"
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/matcher"
+
+	"fmt"
+	"time"
+)
+
+// NewAuditMap maps the groups of the audit by server and id, all of them
+// changed at the given time.
+func NewAuditMap(a *audit.Audit, at time.Time) botenv.AuditMap {
+	return Diff(a, botenv.AuditMap{}, at)
+}
+
+// Diff maps the groups of the audit by server and id, marking those new or
+// changed since the previous audit as changed at the given time.
+func Diff(a *audit.Audit, prev botenv.AuditMap, at time.Time) botenv.AuditMap {
+	var newMap = make(map[string]map[string]matcher.SearchableGroup)
+	for _, server := range a.Servers {
+		newMap[server.Name] = make(map[string]matcher.SearchableGroup)
+		for _, group := range server.Groups {
+			id := fmt.Sprintf("%d", group.Id)
+			changed := at
+			if other, ok := prev.Map[server.Name][id]; ok && !group.Changed(other.Group) {
+				changed = other.Changed
+			}
+			newMap[server.Name][id] = matcher.NewSearchableGroup(server.Name, group, changed)
+		}
+	}
+	return botenv.AuditMap{Map: newMap}
+}
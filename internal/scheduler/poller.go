@@ -0,0 +1,149 @@
+package scheduler
+
+import (
+	"lfm_lookout/internal/audit"
+
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrBackingOff is returned in place of fetching the audit while the poller
+// is waiting out a failure.
+var ErrBackingOff = errors.New("waiting to retry the audit after a failure")
+
+// State is the health of the audit's source.
+type State int
+
+const (
+	// The last fetch succeeded.
+	Healthy State = iota
+	// Fetches are failing, and being retried with backoff.
+	Degraded
+	// Fetches have failed too many times in a row, and are only tried once a
+	// cooldown.
+	BreakerOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Healthy:
+		return "healthy"
+	case Degraded:
+		return "degraded"
+	default:
+		return "breaker open"
+	}
+}
+
+// Health describes how the poller's fetches are faring.
+type Health struct {
+	State    State
+	Failures int
+	// When the last good audit was fetched, or zero if none has been.
+	LastGood  time.Time
+	LastError error
+}
+
+// Poller fetches the audit from its source, backing off exponentially after
+// failures, with jitter, and tripping a circuit breaker after Threshold
+// failures in a row. Fields which aren't positive fall back to defaults.
+type Poller struct {
+	Source Source
+	Clock  Clock
+	// The wait after the first failure, doubling with each one after, up
+	// to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Threshold  int
+	// The wait between fetches while the breaker is open.
+	Cooldown time.Duration
+
+	lock   sync.Mutex
+	health Health
+	// No fetch is tried before this.
+	next time.Time
+}
+
+const (
+	defaultBackoff    time.Duration = time.Minute
+	defaultMaxBackoff time.Duration = time.Minute * 8
+	defaultThreshold  int           = 5
+	defaultCooldown   time.Duration = time.Minute * 15
+)
+
+// Groups fetches the audit, unless the poller is waiting out a failure.
+func (p *Poller) Groups() (*audit.Audit, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := p.Clock.Now()
+	if now.Before(p.next) {
+		return nil, ErrBackingOff
+	}
+	a, err := p.Source.Groups()
+	if err != nil {
+		p.health.Failures++
+		p.health.LastError = err
+		p.health.State = Degraded
+		wait := p.backoff(p.health.Failures)
+		if p.health.Failures >= p.threshold() {
+			p.health.State = BreakerOpen
+			wait = p.cooldown()
+		}
+		p.next = now.Add(jitter(wait))
+		return nil, err
+	}
+	p.health = Health{State: Healthy, LastGood: now}
+	p.next = time.Time{}
+	return a, nil
+}
+
+// Health gives how the poller's fetches are faring.
+func (p *Poller) Health() Health {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.health
+}
+
+func (p *Poller) backoff(failures int) time.Duration {
+	wait := p.Backoff
+	if wait <= 0 {
+		wait = defaultBackoff
+	}
+	most := p.MaxBackoff
+	if most <= 0 {
+		most = defaultMaxBackoff
+	}
+	for i := 1; i < failures && wait < most; i++ {
+		wait *= 2
+	}
+	if wait > most {
+		wait = most
+	}
+	return wait
+}
+
+func (p *Poller) threshold() int {
+	if p.Threshold > 0 {
+		return p.Threshold
+	}
+	return defaultThreshold
+}
+
+func (p *Poller) cooldown() time.Duration {
+	if p.Cooldown > 0 {
+		return p.Cooldown
+	}
+	return defaultCooldown
+}
+
+// jitter spreads the wait by up to a fifth either way, so that retries
+// don't fall in step with the audit period.
+func jitter(d time.Duration) time.Duration {
+	spread := int64(d / 5)
+	if spread <= 0 {
+		return d
+	}
+	return d - time.Duration(spread) + time.Duration(rand.Int63n(2*spread))
+}
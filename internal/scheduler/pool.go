@@ -0,0 +1,257 @@
+package scheduler
+
+import (
+	"lfm_lookout/internal/lodb"
+	"lfm_lookout/internal/matcher"
+	"lfm_lookout/internal/metrics"
+
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// job is a query or watch to match against the index.
+type job struct {
+	query lodb.StoredQuery
+	// Set when the job is a watch rather than a query.
+	watch *lodb.Watch
+}
+
+// outcome is what matching a job turned up.
+type outcome struct {
+	job
+	notifications []Notification
+	// Set when the job failed, or the query was disabled, and is to be
+	// deleted.
+	bad bool
+}
+
+// matchResult is what matching the stored queries and watches turned up.
+type matchResult struct {
+	// The queries and watches matched without a problem.
+	evaluated []lodb.StoredQuery
+	watches   []lodb.Watch
+	// The queries which failed or were disabled, and the watches which
+	// failed.
+	bad        []lodb.StoredQuery
+	badWatches []lodb.Watch
+	queries    int
+	watchCount int
+}
+
+func (s *Scheduler) workers() int {
+	if s.Workers > 0 {
+		return s.Workers
+	}
+	return runtime.NumCPU()
+}
+
+func (s *Scheduler) slowQuery() time.Duration {
+	if s.SlowQuery > 0 {
+		return s.SlowQuery
+	}
+	return defaultSlowQuery
+}
+
+func (s *Scheduler) strikes() int {
+	if s.Strikes > 0 {
+		return s.Strikes
+	}
+	return defaultStrikes
+}
+
+// match streams the stored queries and watches out of the store to a pool of
+// workers, which match them against the index. Those not yet evaluated are
+// matched against all groups, the rest against only the groups changed since
+// they were last evaluated. Matches are handed to the notifier as they're
+// found.
+func (s *Scheduler) match(ctx context.Context, index Index) matchResult {
+	var r matchResult
+	workers := s.workers()
+	jobs := make(chan job, workers)
+	outcomes := make(chan outcome, workers)
+	go s.produce(ctx, jobs, &r)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				outcomes <- s.evaluate(index, j)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(outcomes)
+	}()
+	stale := s.Env.Stale(s.Clock.Now())
+	for o := range outcomes {
+		for _, n := range o.notifications {
+			n.Stale = stale
+			s.Notifier.Notify(n)
+		}
+		switch {
+		case o.watch != nil && o.bad:
+			r.badWatches = append(r.badWatches, *o.watch)
+		case o.watch != nil:
+			r.watches = append(r.watches, *o.watch)
+		case o.bad:
+			r.bad = append(r.bad, o.query)
+		default:
+			r.evaluated = append(r.evaluated, o.query)
+		}
+	}
+	// The producer is done once the outcomes are, so its counts are settled.
+	return r
+}
+
+// produce sends every stored query, then every watch, to the jobs channel,
+// closing it once done or once the context is.
+func (s *Scheduler) produce(ctx context.Context, jobs chan<- job, r *matchResult) {
+	env := s.Env
+	defer close(jobs)
+	err := s.Store.IterateQueries(func(q lodb.StoredQuery) error {
+		r.queries++
+		select {
+		case jobs <- job{query: q}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if ctx.Err() != nil {
+		return
+	} else if err != nil {
+		env.Log.Error(
+			"Error while iterating through queries.",
+			zap.Error(err))
+	}
+	watches, err := s.Store.FindWatches()
+	if err != nil {
+		env.Log.Error(
+			"Error while retrieving watches.",
+			zap.Error(err))
+	}
+	r.watchCount = len(watches)
+	for i := range watches {
+		select {
+		case jobs <- job{watch: &watches[i]}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// evaluate matches a query or watch against the index. A query searched too
+// slowly is given a strike, and the user is warned a strike before it's
+// disabled; a query searched in time has its strikes cleared.
+func (s *Scheduler) evaluate(index Index, j job) outcome {
+	if j.watch != nil {
+		return s.evaluateWatch(index, j)
+	}
+	env := s.Env
+	q := j.query
+	o := outcome{job: j}
+	start := s.Clock.Now()
+	matches, err := index.Match(q.Query, matcher.Filter{Since: q.Evaluated, Open: true, Packs: q.Packs}, SearchSize)
+	took := s.Clock.Now().Sub(start)
+	metrics.QueryMatch.Observe(took.Seconds())
+	env.Log.Debug(
+		"Query search.",
+		zap.Duration("Search_t", took))
+	if err != nil {
+		env.Log.Warn(
+			"Query resulted in error upon searching.",
+			zap.String("query", q.Query),
+			zap.Error(err))
+		o.bad = true
+		o.notify(s.notice(q, fmt.Sprintf("Lookout %X has been disabled, as there was a problem searching with it.", q.Rune)))
+		return o
+	}
+	o.query.Strikes = 0
+	if took > s.slowQuery() {
+		o.query.Strikes = q.Strikes + 1
+		env.Log.Warn(
+			"Query took too long to search against.",
+			zap.String("query", q.Query),
+			zap.Duration("search_t", took),
+			zap.Int("strikes", o.query.Strikes))
+		switch {
+		case o.query.Strikes >= s.strikes():
+			o.bad = true
+			o.notify(s.notice(q, fmt.Sprintf("Lookout %X has been disabled, as it kept taking too long to search. A simpler query may fare better.", q.Rune)))
+			return o
+		case o.query.Strikes == s.strikes()-1:
+			o.notify(s.notice(q, fmt.Sprintf("Lookout %X is taking too long to search, and will be disabled if it stays this slow.", q.Rune)))
+		}
+	}
+	if len(matches) == 0 {
+		return o
+	}
+	ret, err := s.Store.FindReturn(q.AuthorID, q.Rune)
+	if err != nil {
+		env.Log.Error(
+			"Error retrieving the query's return.",
+			zap.Error(err))
+		return o
+	}
+	o.notify(&Notification{
+		Return:  ret,
+		Label:   fmt.Sprintf("ID: %X", q.Rune),
+		Matches: matches,
+	})
+	return o
+}
+
+// notice builds a message to the author of the query, sent where its
+// matches are.
+func (s *Scheduler) notice(q lodb.StoredQuery, message string) *Notification {
+	ret, err := s.Store.FindReturn(q.AuthorID, q.Rune)
+	if err != nil {
+		s.Env.Log.Error(
+			"Error retrieving the query's return.",
+			zap.Error(err))
+		return nil
+	}
+	ret.Mention = fmt.Sprintf("<@%s>", q.AuthorID)
+	return &Notification{Return: ret, Message: message}
+}
+
+func (o *outcome) notify(n *Notification) {
+	if n != nil {
+		o.notifications = append(o.notifications, *n)
+	}
+}
+
+// evaluateWatch matches a watch against the index. A watch which fails to
+// search is disabled, and its creator told where its matches are sent.
+func (s *Scheduler) evaluateWatch(index Index, j job) outcome {
+	w := j.watch
+	o := outcome{job: j}
+	matches, err := index.Match(w.Query, matcher.Filter{Since: w.Evaluated, Open: true, Packs: w.Packs}, SearchSize)
+	if err != nil {
+		s.Env.Log.Warn(
+			"Watch resulted in error upon searching.",
+			zap.String("guild", w.GuildID),
+			zap.String("query", w.Query),
+			zap.Error(err))
+		o.bad = true
+		ret := w.Return()
+		ret.Mention = fmt.Sprintf("<@%s>", w.CreatorID)
+		o.notify(&Notification{Return: ret, Message: fmt.Sprintf("Watch %d has been disabled, as there was a problem searching with it.", w.ID)})
+		return o
+	}
+	if len(matches) > 0 {
+		o.notify(&Notification{
+			Return:  w.Return(),
+			Label:   fmt.Sprintf("Watch: %d", w.ID),
+			Matches: matches,
+		})
+	}
+	return o
+}
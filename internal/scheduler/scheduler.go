@@ -0,0 +1,298 @@
+// Package scheduler runs the bot's ticks. Each tick fetches the audit, diffs
+// it against the previous one, indexes its groups, matches the stored queries
+// and watches against them, and dispatches the matches, before handing the
+// tick to any observers.
+package scheduler
+
+import (
+	"lfm_lookout/internal/audit"
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/lodb"
+	"lfm_lookout/internal/matcher"
+
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// The most matches a query is notified of per tick.
+	SearchSize int = 10
+	// Queries which take longer than this to match are given a strike.
+	defaultSlowQuery time.Duration = time.Millisecond * 50
+	// Queries with this many strikes in a row are disabled.
+	defaultStrikes int = 3
+)
+
+// Source fetches the current audit.
+type Source interface {
+	Groups() (*audit.Audit, error)
+}
+
+// SourceFunc adapts a function to a Source.
+type SourceFunc func() (*audit.Audit, error)
+
+func (f SourceFunc) Groups() (*audit.Audit, error) {
+	return f()
+}
+
+// Store holds the lookout queries and guild watches.
+type Store interface {
+	IterateQueries(fn func(lodb.StoredQuery) error) error
+	FindReturn(authorID string, fr rune) (lodb.Return, error)
+	Delete(authorID string, fr rune) error
+	FindWatches() ([]lodb.Watch, error)
+	MarkAllEvaluated(queries []lodb.StoredQuery, watches []lodb.Watch, at time.Time) error
+	DeleteWatch(guildID string, id int) error
+}
+
+// Index matches queries against a tick's groups.
+type Index = botenv.Index
+
+// Matcher indexes a tick's groups.
+type Matcher func(groups []matcher.SearchableGroup) (Index, error)
+
+// BleveMatcher indexes groups with the matcher package.
+func BleveMatcher(groups []matcher.SearchableGroup) (Index, error) {
+	return matcher.NewIndex(groups)
+}
+
+// Notification is a query's matches, along with where and how they are
+// sent.
+type Notification struct {
+	Return  lodb.Return
+	Label   string
+	Matches []matcher.SearchableGroup
+	// A message sent in place of matches, if any.
+	Message string
+	// How old the matched groups are, if they're stale, and otherwise 0.
+	Stale time.Duration
+}
+
+// Notifier sends notifications.
+type Notifier interface {
+	Notify(n Notification)
+}
+
+// Tick is what a tick saw, as handed to observers.
+type Tick struct {
+	Time time.Time
+	// The audit fetched, or nil if fetching failed, in which case the
+	// previous and current groups are the same.
+	Audit *audit.Audit
+	Prev  botenv.AuditMap
+	Curr  botenv.AuditMap
+	// The index of the current groups, open until the tick after a new
+	// snapshot replaces it.
+	Index   Index
+	Queries int
+	Watches int
+	// The stages of the tick so far, up to matching.
+	Stages Stages
+}
+
+// Observer runs after each tick's matches are dispatched.
+type Observer func(t Tick)
+
+// Scheduler runs ticks, publishing a snapshot of the groups to its bot
+// environment each tick.
+type Scheduler struct {
+	Env       *botenv.BotEnv
+	Clock     Clock
+	Source    Source
+	Store     Store
+	Matcher   Matcher
+	Notifier  Notifier
+	Observers []Observer
+	// The number of queries matched at once, or the number of CPUs if not
+	// positive.
+	Workers int
+	// Queries searched slower than SlowQuery are given a strike, and are
+	// disabled upon Strikes strikes in a row. The user is warned a strike
+	// beforehand. Either falls back to a default if not positive.
+	SlowQuery time.Duration
+	Strikes   int
+	// Only one tick runs at a time.
+	lock sync.Mutex
+	// The index last replaced, which is closed on the next publish.
+	retired Index
+}
+
+// Run ticks every audit period until the context is done. A tick in
+// progress is canceled along with the context.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := s.Clock.NewTicker(time.Second * time.Duration(s.Env.Config.AuditPeriod))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.Chan():
+			s.Step(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Stages is how long each stage of a tick took. Matching includes handing
+// the matches to the notifier as they're found.
+type Stages struct {
+	Fetch   time.Duration
+	Diff    time.Duration
+	Index   time.Duration
+	Match   time.Duration
+	Observe time.Duration
+	Record  time.Duration
+}
+
+// Step runs a single tick. If the context is done partway, the tick stops
+// after its current stage, leaving any unmatched queries for the next tick.
+func (s *Scheduler) Step(ctx context.Context) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	env := s.Env
+	var stages Stages
+	stage := s.stopwatch()
+	startTotal := s.Clock.Now()
+	prevSnapshot := env.Snapshot()
+	prev := prevSnapshot.Audit
+	curr := prev
+	newAudit, err := s.fetch()
+	stages.Fetch = stage()
+	if ctx.Err() != nil {
+		env.Log.Info("Tick canceled.")
+		return
+	}
+	if errors.Is(err, ErrBackingOff) {
+		env.Log.Debug("Waiting to retry the audit.")
+	} else if err != nil {
+		env.Log.Error(
+			"Error updating the audit.",
+			zap.Error(err))
+	} else {
+		curr = Diff(newAudit, prev, startTotal)
+	}
+	stages.Diff = stage()
+	// Without a new audit, keep matching against the last good one rather
+	// than indexing it over again.
+	index := prevSnapshot.Index
+	if newAudit != nil || index == nil {
+		fetched := startTotal
+		if newAudit == nil {
+			fetched = prevSnapshot.Time
+		}
+		index, err = s.index(curr)
+		if err != nil {
+			env.Log.Error(
+				"Error indexing groups.",
+				zap.Error(err))
+			return
+		}
+		s.publish(&botenv.Snapshot{Audit: curr, Index: index, Time: fetched})
+	}
+	stages.Index = stage()
+	if newAudit != nil {
+		env.Log.Info("Audit updated.")
+	}
+	r := s.match(ctx, index)
+	stages.Match = stage()
+	if ctx.Err() != nil {
+		// Record what was matched, so it isn't notified again.
+		s.record(r, startTotal)
+		env.Log.Info("Tick canceled.")
+		return
+	}
+	t := Tick{
+		Time:    startTotal,
+		Audit:   newAudit,
+		Prev:    prev,
+		Curr:    curr,
+		Index:   index,
+		Queries: r.queries,
+		Watches: r.watchCount,
+		Stages:  stages,
+	}
+	for _, observe := range s.Observers {
+		observe(t)
+	}
+	stages.Observe = stage()
+	s.record(r, startTotal)
+	stages.Record = stage()
+	env.Log.Info(
+		"Ticker Loop",
+		zap.Duration("total", s.Clock.Now().Sub(startTotal)),
+		zap.Duration("fetch", stages.Fetch),
+		zap.Duration("diff", stages.Diff),
+		zap.Duration("index", stages.Index),
+		zap.Duration("match", stages.Match),
+		zap.Duration("observe", stages.Observe),
+		zap.Duration("record", stages.Record),
+		zap.Int("workers", s.workers()))
+}
+
+// stopwatch returns a function giving the time since it was last called, or
+// since the stopwatch was made.
+func (s *Scheduler) stopwatch() func() time.Duration {
+	last := s.Clock.Now()
+	return func() time.Duration {
+		now := s.Clock.Now()
+		d := now.Sub(last)
+		last = now
+		return d
+	}
+}
+
+// record marks the queries and watches matched as evaluated at the tick's
+// time, so that they next see only groups changed since, and deletes the
+// disabled queries and watches.
+func (s *Scheduler) record(r matchResult, at time.Time) {
+	env := s.Env
+	if err := s.Store.MarkAllEvaluated(r.evaluated, r.watches, at); err != nil {
+		env.Log.Error(
+			"Error recording the evaluations.",
+			zap.Error(err))
+	}
+	for _, q := range r.bad {
+		if err := s.Store.Delete(q.AuthorID, q.Rune); err != nil {
+			env.Log.Error(
+				"Error deleting query.",
+				zap.Error(err))
+		}
+	}
+	for _, w := range r.badWatches {
+		if err := s.Store.DeleteWatch(w.GuildID, w.ID); err != nil {
+			env.Log.Error(
+				"Error deleting watch.",
+				zap.Error(err))
+		}
+	}
+}
+
+// publish makes the snapshot current. The index of the one it replaces is
+// closed when the next snapshot is published, so that commands still
+// matching against it have a tick to finish.
+func (s *Scheduler) publish(snapshot *botenv.Snapshot) {
+	prev := s.Env.Publish(snapshot)
+	if s.retired != nil {
+		s.retired.Close()
+	}
+	s.retired = prev.Index
+}
+
+// fetch retrieves the current audit.
+func (s *Scheduler) fetch() (*audit.Audit, error) {
+	return s.Source.Groups()
+}
+
+// index indexes every group of the audit map.
+func (s *Scheduler) index(m botenv.AuditMap) (Index, error) {
+	var groups []matcher.SearchableGroup
+	for _, serverMap := range m.Map {
+		for _, sGroup := range serverMap {
+			groups = append(groups, sGroup)
+		}
+	}
+	return s.Matcher(groups)
+}
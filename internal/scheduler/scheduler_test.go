@@ -0,0 +1,591 @@
+package scheduler
+
+import (
+	"lfm_lookout/internal/audit"
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/lodb"
+	"lfm_lookout/internal/matcher"
+
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var errFetch = errors.New("audit unavailable")
+
+var epoch = time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// fakeSource hands out its audits in turn, failing while err is set.
+type fakeSource struct {
+	audits []*audit.Audit
+	err    error
+	calls  int
+}
+
+func (s *fakeSource) Groups() (*audit.Audit, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	a := s.audits[0]
+	if len(s.audits) > 1 {
+		s.audits = s.audits[1:]
+	}
+	return a, nil
+}
+
+// fakeStore keeps queries and watches in memory, as the repository would.
+type fakeStore struct {
+	lock    sync.Mutex
+	queries map[rune]lodb.StoredQuery
+	watches []lodb.Watch
+	deleted []rune
+	// The IDs of the watches deleted.
+	deletedWatches []int
+	batches        int
+}
+
+func newFakeStore(queries ...lodb.StoredQuery) *fakeStore {
+	s := &fakeStore{queries: make(map[rune]lodb.StoredQuery)}
+	for _, q := range queries {
+		s.queries[q.Rune] = q
+	}
+	return s
+}
+
+func (s *fakeStore) IterateQueries(fn func(lodb.StoredQuery) error) error {
+	s.lock.Lock()
+	var queries []lodb.StoredQuery
+	for _, q := range s.queries {
+		queries = append(queries, q)
+	}
+	s.lock.Unlock()
+	sort.Slice(queries, func(i, j int) bool { return queries[i].Rune < queries[j].Rune })
+	for _, q := range queries {
+		if err := fn(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) FindReturn(authorID string, fr rune) (lodb.Return, error) {
+	return lodb.Return{ChannelID: "channel-" + authorID, Style: lodb.StyleText}, nil
+}
+
+func (s *fakeStore) Delete(authorID string, fr rune) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.queries, fr)
+	s.deleted = append(s.deleted, fr)
+	return nil
+}
+
+func (s *fakeStore) FindWatches() ([]lodb.Watch, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]lodb.Watch(nil), s.watches...), nil
+}
+
+// MarkAllEvaluated marks the queries and watches still stored with their
+// creation times, counting each call as one batch.
+func (s *fakeStore) MarkAllEvaluated(queries []lodb.StoredQuery, watches []lodb.Watch, at time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.batches++
+	for _, m := range queries {
+		q, ok := s.queries[m.Rune]
+		if !ok || !q.Created.Equal(m.Created) {
+			continue
+		}
+		q.Evaluated, q.Strikes = at, m.Strikes
+		s.queries[m.Rune] = q
+	}
+	for _, m := range watches {
+		for i := range s.watches {
+			if s.watches[i].GuildID == m.GuildID && s.watches[i].ID == m.ID && s.watches[i].Created.Equal(m.Created) {
+				s.watches[i].Evaluated = at
+			}
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) DeleteWatch(guildID string, id int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range s.watches {
+		if s.watches[i].GuildID == guildID && s.watches[i].ID == id {
+			s.watches = append(s.watches[:i], s.watches[i+1:]...)
+			s.deletedWatches = append(s.deletedWatches, id)
+			return nil
+		}
+	}
+	return lodb.ErrWatchNotFound
+}
+
+func (s *fakeStore) query(fr rune) lodb.StoredQuery {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.queries[fr]
+}
+
+// fakeNotifier collects the notifications, noting each in the log too.
+type fakeNotifier struct {
+	lock  sync.Mutex
+	notes []Notification
+	log   *[]string
+}
+
+func (n *fakeNotifier) Notify(note Notification) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.notes = append(n.notes, note)
+	if n.log != nil {
+		*n.log = append(*n.log, "notify")
+	}
+}
+
+func (n *fakeNotifier) take() []Notification {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	notes := n.notes
+	n.notes = nil
+	return notes
+}
+
+// fakeIndex matches nothing, taking the given time to do so on the clock.
+type fakeIndex struct {
+	clock  *FakeClock
+	took   time.Duration
+	closed bool
+}
+
+func (i *fakeIndex) Match(q string, filter matcher.Filter, size int) ([]matcher.SearchableGroup, error) {
+	if i.closed {
+		return nil, errors.New("index closed")
+	}
+	i.clock.Advance(i.took)
+	return nil, nil
+}
+
+func (i *fakeIndex) Close() error {
+	i.closed = true
+	return nil
+}
+
+func newTestScheduler(clock *FakeClock, source Source, store Store, notifier Notifier) *Scheduler {
+	return &Scheduler{
+		Env: &botenv.BotEnv{
+			Config: &botenv.Configuration{AuditPeriod: 60},
+			Log:    zap.NewNop(),
+		},
+		Clock:    clock,
+		Source:   source,
+		Store:    store,
+		Matcher:  BleveMatcher,
+		Notifier: notifier,
+		Workers:  1,
+	}
+}
+
+func group(id uint64, comment string) audit.Group {
+	return audit.Group{
+		Id:       id,
+		Comment:  comment,
+		MinLevel: 1,
+		MaxLevel: 30,
+		Leader:   audit.Member{Name: fmt.Sprintf("Leader%d", id)},
+	}
+}
+
+func cannith(groups ...audit.Group) *audit.Audit {
+	return &audit.Audit{Servers: []audit.Server{{Name: "Cannith", Groups: groups}}}
+}
+
+func matchedIDs(notes []Notification) []uint64 {
+	var ids []uint64
+	for _, n := range notes {
+		for _, m := range n.Matches {
+			ids = append(ids, m.Group.Id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func TestDiffKeepsUnchangedGroups(t *testing.T) {
+	first, second := epoch, epoch.Add(time.Minute)
+	prev := NewAuditMap(cannith(group(1, "lfm kt"), group(2, "lfm shroud")), first)
+	curr := Diff(cannith(group(1, "lfm kt"), group(2, "lfm shroud, need heals"), group(3, "lfm vod")), prev, second)
+	want := map[string]time.Time{"1": first, "2": second, "3": second}
+	for id, changed := range want {
+		g, ok := curr.Map["Cannith"][id]
+		if !ok {
+			t.Fatalf("group %s missing from the diff", id)
+		}
+		if !g.Changed.Equal(changed) {
+			t.Errorf("group %s changed at %v, want %v", id, g.Changed, changed)
+		}
+	}
+}
+
+func TestStepMatchesOnlyChangedGroups(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	source := &fakeSource{audits: []*audit.Audit{
+		cannith(group(1, "lfm kt"), group(2, "lfm shroud")),
+		cannith(group(1, "lfm kt"), group(2, "lfm shroud, need heals")),
+	}}
+	store := newFakeStore(lodb.StoredQuery{AuthorID: "42", Rune: 0, Query: "+Server:Cannith lfm", Created: epoch})
+	notifier := &fakeNotifier{}
+	s := newTestScheduler(clock, source, store, notifier)
+
+	s.Step(context.Background())
+	if got := matchedIDs(notifier.take()); fmt.Sprint(got) != "[1 2]" {
+		t.Fatalf("first tick matched %v, want [1 2]", got)
+	}
+	if q := store.query(0); !q.Evaluated.Equal(epoch) {
+		t.Fatalf("query evaluated at %v, want %v", q.Evaluated, epoch)
+	}
+
+	clock.Advance(time.Minute)
+	s.Step(context.Background())
+	if got := matchedIDs(notifier.take()); fmt.Sprint(got) != "[2]" {
+		t.Errorf("second tick matched %v, want only the changed group [2]", got)
+	}
+}
+
+func TestStepLeavesReplacedQueryUnevaluated(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	source := &fakeSource{audits: []*audit.Audit{cannith(group(1, "lfm kt"))}}
+	store := newFakeStore(lodb.StoredQuery{AuthorID: "42", Rune: 0, Query: "kt", Created: epoch})
+	s := newTestScheduler(clock, source, store, &fakeNotifier{})
+	// The query is deleted and its ID reused while the tick runs.
+	replaced := epoch.Add(time.Second)
+	s.Observers = []Observer{func(t Tick) {
+		store.lock.Lock()
+		defer store.lock.Unlock()
+		store.queries[0] = lodb.StoredQuery{AuthorID: "42", Rune: 0, Query: "vod", Created: replaced}
+	}}
+
+	s.Step(context.Background())
+	q := store.query(0)
+	if !q.Created.Equal(replaced) {
+		t.Fatal("the replacement query was lost")
+	}
+	if !q.Evaluated.IsZero() {
+		t.Errorf("replacement query marked evaluated at %v, want it matched against every group", q.Evaluated)
+	}
+}
+
+func TestStepReusesIndexOnFailedFetch(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	source := &fakeSource{audits: []*audit.Audit{cannith(group(1, "lfm kt"))}}
+	var indexes []*fakeIndex
+	s := newTestScheduler(clock, source, newFakeStore(), &fakeNotifier{})
+	s.Matcher = func(groups []matcher.SearchableGroup) (Index, error) {
+		i := &fakeIndex{clock: clock}
+		indexes = append(indexes, i)
+		return i, nil
+	}
+	var ticks []Tick
+	s.Observers = []Observer{func(t Tick) { ticks = append(ticks, t) }}
+
+	s.Step(context.Background())
+	source.err = errFetch
+	clock.Advance(time.Minute)
+	s.Step(context.Background())
+
+	if len(indexes) != 1 {
+		t.Fatalf("indexed %d times, want the failed fetch to reuse the index", len(indexes))
+	}
+	if len(ticks) != 2 {
+		t.Fatalf("observed %d ticks, want 2", len(ticks))
+	}
+	failed := ticks[1]
+	if failed.Audit != nil {
+		t.Error("failed tick has an audit")
+	}
+	if failed.Index != Index(indexes[0]) {
+		t.Error("failed tick didn't match against the previous index")
+	}
+	if _, ok := failed.Curr.Map["Cannith"]["1"]; !ok {
+		t.Error("failed tick lost the previous groups")
+	}
+	if snap := s.Env.Snapshot(); !snap.Time.Equal(epoch) {
+		t.Errorf("snapshot time %v, want the last good fetch at %v", snap.Time, epoch)
+	}
+}
+
+func TestPublishClosesReplacedIndexTickLater(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	source := &fakeSource{audits: []*audit.Audit{cannith(group(1, "lfm kt"))}}
+	var indexes []*fakeIndex
+	s := newTestScheduler(clock, source, newFakeStore(), &fakeNotifier{})
+	s.Matcher = func(groups []matcher.SearchableGroup) (Index, error) {
+		i := &fakeIndex{clock: clock}
+		indexes = append(indexes, i)
+		return i, nil
+	}
+
+	s.Step(context.Background())
+	s.Step(context.Background())
+	if indexes[0].closed {
+		t.Fatal("replaced index closed as soon as it was replaced")
+	}
+	s.Step(context.Background())
+	if !indexes[0].closed {
+		t.Error("replaced index still open a tick later")
+	}
+	if indexes[1].closed || indexes[2].closed {
+		t.Error("an index in use was closed")
+	}
+}
+
+func TestPoolMatchesEveryQueryOnce(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	source := &fakeSource{audits: []*audit.Audit{cannith(group(1, "lfm kt"))}}
+	store := newFakeStore()
+	for fr := rune(0); fr < 20; fr++ {
+		store.queries[fr] = lodb.StoredQuery{AuthorID: "42", Rune: fr, Query: "kt", Created: epoch}
+	}
+	notifier := &fakeNotifier{}
+	s := newTestScheduler(clock, source, store, notifier)
+	s.Workers = 4
+	var ticks []Tick
+	s.Observers = []Observer{func(t Tick) { ticks = append(ticks, t) }}
+
+	s.Step(context.Background())
+	labels := make(map[string]int)
+	for _, n := range notifier.take() {
+		labels[n.Label]++
+	}
+	if len(labels) != 20 {
+		t.Errorf("notified %d queries, want all 20", len(labels))
+	}
+	for label, n := range labels {
+		if n != 1 {
+			t.Errorf("%s notified %d times", label, n)
+		}
+	}
+	if ticks[0].Queries != 20 {
+		t.Errorf("tick counted %d queries, want 20", ticks[0].Queries)
+	}
+	for fr := rune(0); fr < 20; fr++ {
+		if q := store.query(fr); !q.Evaluated.Equal(epoch) {
+			t.Errorf("query %d evaluated at %v, want %v", fr, q.Evaluated, epoch)
+		}
+	}
+	if store.batches != 1 {
+		t.Errorf("recorded the evaluations in %d batches, want 1 per tick", store.batches)
+	}
+}
+
+func TestObserversRunInOrderAfterNotifications(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	source := &fakeSource{audits: []*audit.Audit{cannith(group(1, "lfm kt"))}}
+	store := newFakeStore(lodb.StoredQuery{AuthorID: "42", Rune: 0, Query: "+Server:Cannith kt", Created: epoch})
+	var log []string
+	s := newTestScheduler(clock, source, store, &fakeNotifier{log: &log})
+	for _, name := range []string{"archive", "boards", "presence"} {
+		name := name
+		s.Observers = append(s.Observers, func(t Tick) {
+			log = append(log, name)
+		})
+	}
+
+	s.Step(context.Background())
+	if got := strings.Join(log, " "); got != "notify archive boards presence" {
+		t.Errorf("ran %q, want notifications, then observers in order", got)
+	}
+}
+
+func TestCanceledStepStopsBeforeMatching(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	source := &fakeSource{audits: []*audit.Audit{cannith(group(1, "lfm kt"))}}
+	store := newFakeStore(lodb.StoredQuery{AuthorID: "42", Rune: 0, Query: "kt", Created: epoch})
+	notifier := &fakeNotifier{}
+	s := newTestScheduler(clock, source, store, notifier)
+	observed := false
+	s.Observers = []Observer{func(t Tick) { observed = true }}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Step(ctx)
+	if notes := notifier.take(); len(notes) != 0 {
+		t.Errorf("canceled tick notified %v", notes)
+	}
+	if observed {
+		t.Error("canceled tick ran the observers")
+	}
+	if q := store.query(0); !q.Evaluated.IsZero() {
+		t.Errorf("canceled tick marked the query evaluated at %v", q.Evaluated)
+	}
+}
+
+func TestSlowQueriesAreStruckAndDisabled(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	source := &fakeSource{audits: []*audit.Audit{cannith(group(1, "lfm kt"))}}
+	store := newFakeStore(
+		lodb.StoredQuery{AuthorID: "42", Rune: 0, Query: "slow", Created: epoch},
+	)
+	notifier := &fakeNotifier{}
+	s := newTestScheduler(clock, source, store, notifier)
+	s.SlowQuery = time.Millisecond * 50
+	s.Strikes = 3
+	s.Matcher = func(groups []matcher.SearchableGroup) (Index, error) {
+		return &fakeIndex{clock: clock, took: time.Millisecond * 100}, nil
+	}
+
+	s.Step(context.Background())
+	if q := store.query(0); q.Strikes != 1 {
+		t.Fatalf("query has %d strikes after a slow search, want 1", q.Strikes)
+	}
+	if notes := notifier.take(); len(notes) != 0 {
+		t.Fatalf("warned after the first strike: %v", notes)
+	}
+
+	clock.Advance(time.Minute)
+	s.Step(context.Background())
+	notes := notifier.take()
+	if q := store.query(0); q.Strikes != 2 {
+		t.Fatalf("query has %d strikes, want 2", q.Strikes)
+	}
+	if len(notes) != 1 || !strings.Contains(notes[0].Message, "will be disabled") {
+		t.Fatalf("got %v, want a warning a strike before disabling", notes)
+	}
+	if notes[0].Return.Mention != "<@42>" {
+		t.Errorf("warning mentions %q, want the author", notes[0].Return.Mention)
+	}
+
+	clock.Advance(time.Minute)
+	s.Step(context.Background())
+	notes = notifier.take()
+	if len(notes) != 1 || !strings.Contains(notes[0].Message, "has been disabled") {
+		t.Fatalf("got %v, want the query disabled", notes)
+	}
+	if fmt.Sprint(store.deleted) != "[0]" {
+		t.Errorf("deleted %v, want the disabled query", store.deleted)
+	}
+}
+
+func TestFastSearchClearsStrikes(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	source := &fakeSource{audits: []*audit.Audit{cannith(group(1, "lfm kt"))}}
+	store := newFakeStore(lodb.StoredQuery{AuthorID: "42", Rune: 0, Query: "kt", Created: epoch, Strikes: 2})
+	s := newTestScheduler(clock, source, store, &fakeNotifier{})
+	s.Matcher = func(groups []matcher.SearchableGroup) (Index, error) {
+		return &fakeIndex{clock: clock}, nil
+	}
+
+	s.Step(context.Background())
+	if q := store.query(0); q.Strikes != 0 {
+		t.Errorf("query has %d strikes after a fast search, want 0", q.Strikes)
+	}
+}
+
+func TestFailingWatchesAreDisabled(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	source := &fakeSource{audits: []*audit.Audit{cannith(group(1, "lfm kt"))}}
+	store := newFakeStore()
+	store.watches = []lodb.Watch{
+		{GuildID: "7", ID: 1, ChannelID: "channel-7", CreatorID: "42", Query: "+Server:Cannith Level:>=x", Created: epoch},
+		{GuildID: "7", ID: 2, ChannelID: "channel-7", CreatorID: "42", Query: "+Server:Cannith kt", Created: epoch},
+	}
+	notifier := &fakeNotifier{}
+	s := newTestScheduler(clock, source, store, notifier)
+
+	s.Step(context.Background())
+	var disabled []Notification
+	for _, n := range notifier.take() {
+		if n.Message != "" {
+			disabled = append(disabled, n)
+		}
+	}
+	if len(disabled) != 1 || !strings.Contains(disabled[0].Message, "Watch 1 has been disabled") {
+		t.Fatalf("got %v, want watch 1 disabled", disabled)
+	}
+	if ret := disabled[0].Return; ret.ChannelID != "channel-7" || ret.Mention != "<@42>" {
+		t.Errorf("notice sent to %+v, want the watch's channel, mentioning its creator", ret)
+	}
+	if fmt.Sprint(store.deletedWatches) != "[1]" {
+		t.Errorf("deleted watches %v, want [1]", store.deletedWatches)
+	}
+
+	clock.Advance(time.Minute)
+	s.Step(context.Background())
+	if notes := notifier.take(); len(notes) != 0 {
+		t.Errorf("got %v on the next tick, want the watch gone", notes)
+	}
+}
+
+// expectWait checks that the poller waits out at least the low end of the
+// jittered wait before fetching, and fetches by its high end.
+func expectWait(t *testing.T, p *Poller, clock *FakeClock, source *fakeSource, wait time.Duration) {
+	t.Helper()
+	calls := source.calls
+	low, high := wait-wait/5, wait+wait/5
+	clock.Advance(low - time.Second)
+	if _, err := p.Groups(); !errors.Is(err, ErrBackingOff) {
+		t.Fatalf("fetched before waiting %v: %v", low, err)
+	}
+	if source.calls != calls {
+		t.Fatal("the source was fetched from while backing off")
+	}
+	clock.Advance(high - low + time.Second)
+	p.Groups()
+	if source.calls != calls+1 {
+		t.Fatalf("didn't fetch once %v had passed", high)
+	}
+}
+
+func TestPollerBacksOffAndBreaks(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	source := &fakeSource{audits: []*audit.Audit{cannith()}, err: errFetch}
+	p := &Poller{
+		Source:     source,
+		Clock:      clock,
+		Backoff:    time.Minute,
+		MaxBackoff: time.Minute * 3,
+		Threshold:  4,
+		Cooldown:   time.Minute * 15,
+	}
+
+	if _, err := p.Groups(); !errors.Is(err, errFetch) {
+		t.Fatalf("got %v, want the source's error", err)
+	}
+	if h := p.Health(); h.State != Degraded || h.Failures != 1 || h.LastError != errFetch {
+		t.Fatalf("health %+v after a failure, want degraded", h)
+	}
+	// The wait doubles with each failure, up to the most.
+	expectWait(t, p, clock, source, time.Minute)
+	expectWait(t, p, clock, source, time.Minute*2)
+	if h := p.Health(); h.State != Degraded || h.Failures != 3 {
+		t.Fatalf("health %+v, want degraded after 3 failures", h)
+	}
+	expectWait(t, p, clock, source, time.Minute*3)
+	if h := p.Health(); h.State != BreakerOpen || h.Failures != 4 {
+		t.Fatalf("health %+v, want the breaker open after 4 failures", h)
+	}
+	// With the breaker open, fetches are only tried once a cooldown.
+	expectWait(t, p, clock, source, time.Minute*15)
+
+	source.err = nil
+	clock.Advance(time.Minute * 18)
+	if _, err := p.Groups(); err != nil {
+		t.Fatalf("got %v once the source recovered", err)
+	}
+	if h := p.Health(); h.State != Healthy || h.Failures != 0 || !h.LastGood.Equal(clock.Now()) {
+		t.Errorf("health %+v after recovering, want healthy", h)
+	}
+	if _, err := p.Groups(); err != nil {
+		t.Errorf("got %v, want fetches right away once healthy", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/lodb"
+
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The environment variables encryption keys are read from, ahead of the
+// key files in the config.
+const (
+	keyEnv    = "LOOKOUT_ENCRYPTION_KEY"
+	oldKeyEnv = "LOOKOUT_OLD_ENCRYPTION_KEY"
+)
+
+// repoOptions gives how to open the store, with the encryption keys read
+// from the environment or the key files in the config.
+func repoOptions(config *botenv.Configuration) (lodb.RepoOptions, error) {
+	var opts lodb.RepoOptions
+	var err error
+	if opts.EncryptionKey, err = loadKey(keyEnv, config.EncryptionKeyFile); err != nil {
+		return opts, err
+	}
+	if opts.OldEncryptionKey, err = loadKey(oldKeyEnv, config.OldEncryptionKeyFile); err != nil {
+		return opts, err
+	}
+	if len(opts.EncryptionKey) == 0 && len(opts.OldEncryptionKey) > 0 {
+		return opts, fmt.Errorf("an old encryption key was given without a new one")
+	}
+	opts.KeyRotation = time.Hour * 24 * time.Duration(config.KeyRotationDays)
+	return opts, nil
+}
+
+// loadKey reads a key from the environment variable, or else from the file,
+// or gives nil if neither is set.
+func loadKey(env, path string) ([]byte, error) {
+	if s := os.Getenv(env); s != "" {
+		key, err := lodb.ParseKey(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", env, err)
+		}
+		return key, nil
+	}
+	if path == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := lodb.ParseKey(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// restore loads a backup into the store, and closes it.
+func restore(botEnv *botenv.BotEnv, path string) {
+	defer botEnv.Repo.Close()
+	if err := botEnv.Repo.Restore(path); err != nil {
+		botEnv.Log.Error(
+			"Error restoring the backup.",
+			zap.Error(err))
+		fmt.Println("Unable to restore the backup:", err)
+		return
+	}
+	botEnv.Log.Info(
+		"Restored Backup",
+		zap.String("path", path))
+	fmt.Println("Restored", path)
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"lfm_lookout/internal/archive"
 	"lfm_lookout/internal/audit"
 	"lfm_lookout/internal/botcmds"
 	"lfm_lookout/internal/botenv"
 	"lfm_lookout/internal/lodb"
+	"lfm_lookout/internal/matcher"
+	"lfm_lookout/internal/scheduler"
 
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -13,12 +17,11 @@ import (
 	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/blevesearch/bleve/v2"
 	dg "github.com/bwmarrin/discordgo"
-	badger "github.com/dgraph-io/badger/v3"
 	"github.com/natefinch/lumberjack"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -29,11 +32,8 @@ func main() {
 	log := getLogger()
 	defer log.Sync()
 	log.Info("Logging to file.")
-	auditLock := new(sync.RWMutex)
-	tickLock := new(sync.RWMutex)
-	n := time.Now()
-	tick := rune((n.Hour() * 120) + (n.Minute() * 2) + (n.Second() / 30))
-	botEnv := botenv.BotEnv{Log: log, AuditLock: auditLock, Tick: tick, TickLock: tickLock}
+	repl := len(os.Args) > 1 && os.Args[1] == "repl"
+	botEnv := botenv.BotEnv{Log: log}
 	// Load the config.json file.
 	io, err := ioutil.ReadFile("config.json")
 	if err != nil {
@@ -43,23 +43,93 @@ func main() {
 	}
 	// Load JSON into botenv:config.
 	json.Unmarshal(io, &botEnv.Config)
-	// Initialize LoRepo
-	repo, err := lodb.NewLoRepo("./badger")
+	if botEnv.Config.AuditPeriod < 30 {
+		log.Panic("Audit period is faster than the PlayerAudit API allows.")
+	}
+	if botEnv.Config.SynonymsPath != "" {
+		if err := matcher.LoadSynonyms(botEnv.Config.SynonymsPath); err != nil {
+			log.Fatal(
+				"Error loading the abbreviation dictionary.",
+				zap.Error(err))
+		}
+	}
+	// Initialize LoRepo, keeping the REPL's queries apart from the bot's.
+	repoPath := "./badger"
+	if repl {
+		repoPath = "./badger-repl"
+	}
+	// The store is encrypted when a key is given, and a plaintext store is
+	// encrypted as it's opened.
+	repoOpts, err := repoOptions(botEnv.Config)
+	if err != nil {
+		log.Fatal(
+			"Error loading the encryption key.",
+			zap.Error(err))
+	}
+	repo, err := lodb.OpenLoRepo(repoPath, repoOpts)
 	if err != nil {
 		log.Panic(
 			"Error initializing the query repository.",
 			zap.Error(err))
 	}
 	botEnv.Repo = repo
+	// Restore a backup into the store, if asked to, instead of running.
+	if len(os.Args) > 2 && os.Args[1] == "restore" {
+		restore(&botEnv, os.Args[2])
+		return
+	}
+	// Open the group archive, if enabled.
+	if botEnv.Config.ArchivePath != "" {
+		archivePath := botEnv.Config.ArchivePath
+		if repl {
+			archivePath = strings.TrimSuffix(archivePath, ".db") + "-repl.db"
+		}
+		retention := time.Hour * 24 * time.Duration(botEnv.Config.ArchiveRetentionDays)
+		arch, err := archive.Open(archivePath, retention)
+		if err != nil {
+			log.Panic(
+				"Error opening the group archive.",
+				zap.Error(err))
+		}
+		botEnv.Archive = arch
+	}
 	// TODO: Clean up orphan query and return entries.
-	// Get current groups from playeraudit.com
-	currAudit, err := audit.Groups()
-	if err != nil {
+	// Get current groups from playeraudit.com, or from a saved audit file when
+	// one is given to the REPL.
+	var source scheduler.Source = scheduler.SourceFunc(audit.Groups)
+	if repl && len(os.Args) > 2 {
+		auditPath := os.Args[2]
+		source = scheduler.SourceFunc(func() (*audit.Audit, error) {
+			return audit.GroupsFromFile(auditPath)
+		})
+	}
+	// Fetch through a poller, which backs off when the audit fails.
+	poller := &scheduler.Poller{
+		Source:  timeSource(source),
+		Clock:   scheduler.RealClock(),
+		Backoff: time.Second * time.Duration(botEnv.Config.AuditPeriod),
+	}
+	currAudit, err := poller.Groups()
+	if err != nil && !repl {
 		log.Fatal(
 			"Error getting the groups audit.",
 			zap.Error(err))
+	} else if err != nil {
+		fmt.Println("Unable to get the groups audit, starting with no groups:", err)
+		botEnv.Publish(&botenv.Snapshot{
+			Audit: botenv.AuditMap{Map: make(map[string]map[string]matcher.SearchableGroup)},
+			Time:  time.Now(),
+		})
 	} else {
-		botEnv.Audit = AuditToMap(currAudit)
+		now := time.Now()
+		botEnv.Publish(&botenv.Snapshot{Audit: scheduler.NewAuditMap(currAudit, now), Time: now})
+	}
+	// Embed BotEnv in LookoutEnv so BotEnv can be passed to commands.
+	loEnv := LookoutEnv{Env: &botEnv, Poller: poller, outbox: newOutbox()}
+	loEnv.wrapCommands()
+	if repl {
+		loEnv.runRepl(os.Stdin, os.Stdout)
+		return
 	}
 	// Create a new Discord session using the provided bot token.
 	bot, err := dg.New("Bot " + botEnv.Config.Token)
@@ -68,8 +138,6 @@ func main() {
 			"Error initializing the bot.",
 			zap.Error(err))
 	}
-	// Embed BotEnv in LookoutEnv so BotEnv can be passed to commands.
-	loEnv := LookoutEnv{Env: &botEnv}
 	// Register the messageCreate func as a callback for MessageCreate events.
 	bot.AddHandler(loEnv.messageCreate)
 	// We only care about message events, so let's make that clear.
@@ -81,218 +149,59 @@ func main() {
 			"Error connecting the bot.",
 			zap.Error(err))
 	}
-	// Periodically update botEnv.Audit.
-	if botEnv.Config.AuditPeriod < 30 {
-		log.Panic("Audit period is faster than the PlayerAudit API allows.")
+	// Periodically publish a new snapshot of the groups.
+	ctx, cancel := context.WithCancel(context.Background())
+	sched := loEnv.newScheduler(bot)
+	loEnv.background(func() {
+		sched.Run(ctx)
+	})
+	// Keep the store tidy.
+	loEnv.background(func() {
+		loEnv.maintain(ctx)
+	})
+	// Serve metrics, if enabled.
+	if botEnv.Config.MetricsEnabled {
+		loEnv.background(func() {
+			loEnv.serveMetrics(ctx)
+		})
 	}
-	auditTicker := time.NewTicker(time.Second * time.Duration(botEnv.Config.AuditPeriod))
-	quit := make(chan bool)
-	go func() {
-		for {
-			select {
-			// Update audit, cull expired queries, then run queries on audit
-			case <-auditTicker.C:
-				// Update audit.
-				startTotal := time.Now()
-				newAudit, err := audit.Groups()
-				if err != nil {
-					botEnv.Log.Error(
-						"Error updating the audit.",
-						zap.Error(err))
-				} else {
-					botEnv.AuditLock.Lock()
-					prevAudit := botEnv.Audit
-					botEnv.Audit = AuditToUpdatedMap(newAudit, prevAudit)
-					botEnv.AuditLock.Unlock()
-					botEnv.Log.Info("Audit updated.")
-				}
-				// Open a new index.
-				startIndex := time.Now()
-				// mapping := bleve.NewIndexMapping()
-				mapping, _ := buildIndexMapping()
-				index, err := bleve.NewMemOnly(mapping)
-				if err != nil {
-					botEnv.Log.Error(
-						"Error initializing the index.",
-						zap.Error(err))
-					continue
-				}
-				batch := index.NewBatch()
-				botEnv.AuditLock.RLock()
-				for _, serverMap := range botEnv.Audit.Map {
-					for idStr, sGroup := range serverMap {
-						batch.Index(idStr, sGroup)
-					}
-				}
-				botEnv.AuditLock.RUnlock()
-				if err := index.Batch(batch); err != nil {
-					botEnv.Log.Error(
-						"Error indexing batch of groups.",
-						zap.Error(err))
-					continue
-				}
-				// fmt.Println(index.Fields())
-				startSearch := time.Now()
-				botEnv.TickLock.Lock()
-				currTick := botEnv.Tick
-				botEnv.Tick = lodb.NextTickRune(currTick)
-				botEnv.TickLock.Unlock()
-				// Run queries on current groups.
-				var delQ []string
-				var qNum int
-				errReIt := repo.GetView(func(txn *badger.Txn) error {
-					it := txn.NewIterator(badger.DefaultIteratorOptions)
-					prefix := []byte("query")
-					for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-						qNum++
-						item := it.Item()
-						key := string(item.Key())
-						r := lodb.GetIDFromKey(key)
-						_, t := lodb.DecodeFinalRune(r)
-						// If the query is from the next tick, defer until then.
-						botEnv.TickLock.RLock()
-						if t == botEnv.Tick {
-							continue
-						}
-						botEnv.TickLock.RUnlock()
-						err := item.Value(func(v []byte) error {
-							val := string(v)
-							qsStart := time.Now()
-							queryBase := bleve.NewQueryStringQuery(val)
-							// If query is from the preceding tick, run against all
-							// groups, otherwise, run against only fresh ones.
-							var search *bleve.SearchRequest
-							if t != currTick {
-								onlyFresh := bleve.NewBoolFieldQuery(true)
-								onlyFresh.SetField("Fresh")
-								query := bleve.NewConjunctionQuery(queryBase, onlyFresh)
-								search = bleve.NewSearchRequest(query)
-							} else {
-								search = bleve.NewSearchRequest(queryBase)
-							}
-							search.Fields = []string{"Server"}
-							// TODO: Mark queries that take too long to search.
-							searchResults, err := index.Search(search)
-							qs := time.Since(qsStart)
-							if qs > (time.Millisecond * 50) {
-								botEnv.Log.Warn(
-									"Query took too long to search against.",
-									zap.String("query", val),
-									zap.Duration("search_t", qs))
-								delQ = append(delQ, key)
-							}
-							botEnv.Log.Debug(
-								"Query search.",
-								zap.Duration("Search_t", qs))
-							if err != nil {
-								botEnv.Log.Warn(
-									"Query resulted in error upon searching.",
-									zap.String("query", val))
-								delQ = append(delQ, key)
-								return err
-							}
-							botEnv.AuditLock.RLock()
-							mt := time.Now()
-							var b strings.Builder // For combining hits into a single message.
-							var channel []byte
-							if len(searchResults.Hits) > 0 {
-								chanKey := strings.Replace(key, "query", "return", 1)
-								chanItem, err := txn.Get([]byte(chanKey))
-								if err != nil {
-									botEnv.Log.Error(
-										"Error getting the channel id kv pair.",
-										zap.Error(err))
-									return err
-								}
-								errVal := chanItem.Value(func(val []byte) error {
-									channel = append([]byte{}, val...)
-									return nil
-								})
-								if errVal != nil {
-									botEnv.Log.Error(
-										"Error retrieving the channel id value.",
-										zap.Error(errVal))
-									return errVal
-								}
-							}
-							// Review each match and act accordingly.
-							for _, match := range searchResults.Hits {
-								sGroup, exists := botEnv.Audit.Map[match.Fields["Server"].(string)][match.ID]
-								if exists {
-									m := fmt.Sprintf("**ID: %X**, %s\n%s\n", r, sGroup.Server, sGroup.Group.String())
-									b.WriteString(m)
-								} else {
-									botEnv.Log.Warn(
-										"Group match was not found in Audit map.",
-										zap.String("query", val),
-										zap.String("server", match.Fields["Server"].(string)))
-								}
-							}
-							go func(channel string, message string) {
-								bot.ChannelMessageSend(channel, message)
-							}(string(channel), b.String())
-							botEnv.Log.Debug(
-								"Match iteration.",
-								zap.Duration("matches_t", time.Since(mt)))
-							botEnv.AuditLock.RUnlock()
-							return nil
-						})
-						if err != nil {
-							botEnv.Log.Error(
-								"Error while using query's value.",
-								zap.Error(err))
-							continue
-						}
-					}
-					it.Close()
-					return nil
-				})
-				if errReIt != nil {
-					botEnv.Log.Error(
-						"Error while iterating through queries.",
-						zap.Error(errReIt))
-				}
-				stop := time.Now()
-				botEnv.Log.Info(
-					"Ticker Loop",
-					zap.Duration("total", stop.Sub(startTotal)),
-					zap.Duration("auditing", startIndex.Sub(startTotal)),
-					zap.Duration("indexing", startSearch.Sub(startIndex)),
-					zap.Duration("searching", stop.Sub(startSearch)))
-				index.Close()
-				// Log some of the bot's stats.
-				botEnv.Log.Info(
-					"Bot Statistics",
-					zap.Int("guilds", len(bot.State.Ready.Guilds)),
-					zap.Int("queries", qNum))
-				// Delete problematic queries.
-				for i := range delQ {
-					botEnv.Repo.Delete(lodb.GetAuthFromKey(delQ[i]), lodb.GetIDFromKey(delQ[i]))
-				}
-			case <-quit:
-				auditTicker.Stop()
-				return
-			}
-		}
-	}()
-	defer close(quit)
 	// Wait here until CTRL-C or other term signal is received.
 	fmt.Println("Bot is now running.  Press CTRL-C to exit.")
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	<-sc
-	// Cleanly close down the Discord session.
-	quit <- true
-	bot.Close()
+	// Cleanly close down the Discord session, and everything else.
+	loEnv.shutdown(bot, cancel)
 }
 
 type LookoutEnv struct {
 	Env *botenv.BotEnv
+	// Poller retrieves the current audit.
+	Poller   *scheduler.Poller
+	handlers map[string]botcmds.Handler
+	// The last presence set, to skip setting it again.
+	presence string
+	// Sends messages in the background.
+	outbox *outbox
+	// Set once shutting down, after which commands are ignored. Held for
+	// reading while a command is counted as running.
+	closing   int32
+	closeLock sync.RWMutex
+	// The goroutines, and the commands, to wait out on shutdown.
+	running  sync.WaitGroup
+	commands sync.WaitGroup
+	// Set while boards are being edited.
+	boardsBusy  int32
+	boardHashes map[string]string
 }
 
 // This function will be called (due to AddHandler above) every time a new
 // message is created on any channel that the authenticated bot has access to.
 func (env *LookoutEnv) messageCreate(s *dg.Session, m *dg.MessageCreate) {
+	// Ignore all messages once shutting down.
+	if atomic.LoadInt32(&env.closing) == 1 {
+		return
+	}
 	// Ignore all messages created by the bot itself.
 	if m.Author.ID == s.State.User.ID {
 		return
@@ -305,70 +214,75 @@ func (env *LookoutEnv) messageCreate(s *dg.Session, m *dg.MessageCreate) {
 	if m.WebhookID != "" {
 		return
 	}
-	// If it's a message we care about, check if it's a command, and execute.
-	if strings.HasPrefix(m.Content, env.Env.Config.Prefix) {
-		var strTokens = strings.Fields(m.Content[len(env.Env.Config.Prefix):])
+	env.dispatch(s, m)
+}
+
+// dispatch checks if the message is a command, and executes it. Commands are
+// handed the message with the guild's prefix removed from its content.
+func (env *LookoutEnv) dispatch(s botcmds.Session, m *dg.MessageCreate) {
+	settings := lodb.GuildSettings{GuildID: m.GuildID}
+	if m.GuildID != "" {
+		var err error
+		settings, err = env.Env.Repo.FindGuildSettings(m.GuildID)
+		if err != nil {
+			env.Env.Log.Error(
+				"Error retrieving guild settings.",
+				zap.String("guild", m.GuildID),
+				zap.Error(err))
+		}
+	}
+	prefix := env.Env.Config.Prefix
+	if settings.Prefix != "" {
+		prefix = settings.Prefix
+	}
+	if strings.HasPrefix(m.Content, prefix) {
+		var strTokens = strings.Fields(m.Content[len(prefix):])
 		if len(strTokens) > 0 {
 			var c = strings.ToLower(strTokens[0])
-			if c == "help" {
-				switch len(strTokens) {
-				case 1:
-					s.ChannelMessageSendEmbed(m.ChannelID, &botcmds.CommandsMsg)
-				case 2:
-					command, ok := botcmds.Commands[strings.ToLower(strTokens[1])]
-					if ok {
-						s.ChannelMessageSendEmbed(m.ChannelID, &command.HelpMsg)
-					}
-				default:
-					return
-				}
+			// Outside of the allowed channels, only answer to the config
+			// command, so the allowed channels can still be changed.
+			if !settings.Allows(m.ChannelID) && c != "config" {
+				return
 			}
-			command, ok := botcmds.Commands[c]
+			handler, ok := env.handlers[c]
 			if ok {
-				command.Cmd(s, m, env.Env)
+				stripped := *m.Message
+				stripped.Content = strings.TrimSpace(m.Content[len(prefix):])
+				handler(s, &dg.MessageCreate{Message: &stripped}, env.Env)
 			}
 		}
 	}
 }
 
-func AuditToMap(audit *audit.Audit) botenv.AuditMap {
-	var newMap = make(map[string]map[string]botenv.SearchableGroup)
-	for _, server := range audit.Servers {
-		newMap[server.Name] = make(map[string]botenv.SearchableGroup)
-		for _, group := range server.Groups {
-			newMap[server.Name][fmt.Sprintf("%d", group.Id)] = botenv.SearchableGroup{
-				Server:  server.Name,
-				Group:   group,
-				Members: 1 + len(group.Members),
-				Fresh:   true,
-			}
-		}
+// wrapCommands builds the handlers for each command, wrapped in the
+// middleware every command runs through.
+func (env *LookoutEnv) wrapCommands() {
+	config := env.Env.Config
+	if config.RateWindow <= 0 {
+		config.RateWindow = 10
 	}
-	return botenv.AuditMap{Map: newMap}
-}
-
-func AuditToUpdatedMap(audit *audit.Audit, prevMap botenv.AuditMap) botenv.AuditMap {
-	var newMap = make(map[string]map[string]botenv.SearchableGroup)
-	for _, server := range audit.Servers {
-		newMap[server.Name] = make(map[string]botenv.SearchableGroup)
-		for _, group := range server.Groups {
-			id := fmt.Sprintf("%d", group.Id)
-			var f bool
-			other, ok := prevMap.Map[server.Name][id]
-			if ok {
-				f = group.Changed(other.Group)
-			} else {
-				f = true
-			}
-			newMap[server.Name][id] = botenv.SearchableGroup{
-				Server:  server.Name,
-				Group:   group,
-				Members: 1 + len(group.Members),
-				Fresh:   f,
-			}
-		}
+	if config.UserRateLimit <= 0 {
+		config.UserRateLimit = 5
+	}
+	if config.ChannelRateLimit <= 0 {
+		config.ChannelRateLimit = 10
+	}
+	window := time.Second * time.Duration(config.RateWindow)
+	middleware := []botcmds.Middleware{
+		env.tracking(),
+		botcmds.Logging(),
+		botcmds.Metrics(),
+		botcmds.Recovery(),
+		botcmds.RateLimit(
+			botcmds.NewRateLimiter(config.UserRateLimit, window),
+			botcmds.NewRateLimiter(config.ChannelRateLimit, window)),
+		botcmds.Permissions(),
+	}
+	env.handlers = make(map[string]botcmds.Handler)
+	for name, command := range botcmds.Commands {
+		env.handlers[name] = botcmds.Wrap(name, command, middleware...)
 	}
-	return botenv.AuditMap{Map: newMap}
+	env.handlers["help"] = botcmds.Wrap("help", botcmds.Command{Cmd: botcmds.Help}, middleware...)
 }
 
 func getLogger() *zap.Logger {
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maintain collects the store's value log garbage and reports its size every
+// GC period, and backs it up every backup period if backups are enabled,
+// until the context is done.
+func (env *LookoutEnv) maintain(ctx context.Context) {
+	config := env.Env.Config
+	gcPeriod := time.Minute * time.Duration(config.GCPeriodMinutes)
+	if gcPeriod <= 0 {
+		gcPeriod = time.Minute * 10
+	}
+	gcTicker := time.NewTicker(gcPeriod)
+	defer gcTicker.Stop()
+	var backups <-chan time.Time
+	if config.BackupDir != "" {
+		backupPeriod := time.Hour * time.Duration(config.BackupHours)
+		if backupPeriod <= 0 {
+			backupPeriod = time.Hour * 24
+		}
+		backupTicker := time.NewTicker(backupPeriod)
+		defer backupTicker.Stop()
+		backups = backupTicker.C
+	}
+	for {
+		select {
+		case <-gcTicker.C:
+			env.collectGarbage()
+		case <-backups:
+			env.backup()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// collectGarbage collects the store's value log garbage, then logs the
+// store's size.
+func (env *LookoutEnv) collectGarbage() {
+	botEnv := env.Env
+	ratio := botEnv.Config.GCDiscardRatio
+	if ratio <= 0 || ratio >= 1 {
+		ratio = 0.5
+	}
+	start := time.Now()
+	rewrites, err := botEnv.Repo.CollectGarbage(ratio)
+	if err != nil {
+		botEnv.Log.Error(
+			"Error collecting the store's garbage.",
+			zap.Error(err))
+	}
+	stats, err := botEnv.Repo.Stats()
+	if err != nil {
+		botEnv.Log.Error(
+			"Error measuring the store.",
+			zap.Error(err))
+		return
+	}
+	botEnv.Log.Info(
+		"Store Maintenance",
+		zap.Int("rewrites", rewrites),
+		zap.Duration("gc_t", time.Since(start)),
+		zap.Int64("lsm_bytes", stats.LSMSize),
+		zap.Int64("vlog_bytes", stats.VLogSize),
+		zap.Int("keys", stats.Total),
+		zap.Any("keys_by_prefix", stats.Keys))
+}
+
+// backup backs the store up to the backup directory.
+func (env *LookoutEnv) backup() {
+	botEnv := env.Env
+	keep := botEnv.Config.BackupKeep
+	if keep <= 0 {
+		keep = 7
+	}
+	path, err := botEnv.Repo.Backup(botEnv.Config.BackupDir, keep)
+	if err != nil {
+		botEnv.Log.Error(
+			"Error backing up the store.",
+			zap.Error(err))
+		return
+	}
+	botEnv.Log.Info(
+		"Store backed up.",
+		zap.String("path", path))
+}
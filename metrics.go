@@ -0,0 +1,72 @@
+package main
+
+import (
+	"lfm_lookout/internal/audit"
+	"lfm_lookout/internal/botcmds"
+	"lfm_lookout/internal/metrics"
+	"lfm_lookout/internal/scheduler"
+
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The address metrics are served on when none is configured.
+const defaultMetricsAddress = "localhost:2112"
+
+// timeSource wraps the source, timing each fetch and counting failures.
+func timeSource(source scheduler.Source) scheduler.Source {
+	return scheduler.SourceFunc(func() (*audit.Audit, error) {
+		start := time.Now()
+		a, err := source.Groups()
+		metrics.AuditFetch.Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.AuditFailures.Inc()
+		}
+		return a, err
+	})
+}
+
+// recordMetrics updates the metrics which follow the tick.
+func (env *LookoutEnv) recordMetrics(session botcmds.Session, t scheduler.Tick) {
+	if t.Audit != nil {
+		metrics.IndexBuild.Observe(t.Stages.Index.Seconds())
+	}
+	metrics.Groups.Reset()
+	for server, groups := range t.Curr.Map {
+		metrics.Groups.WithLabelValues(server).Set(float64(len(groups)))
+	}
+	metrics.AuditAge.Set(t.Time.Sub(env.Env.Snapshot().Time).Seconds())
+	metrics.Queries.Set(float64(t.Queries))
+	metrics.Watches.Set(float64(t.Watches))
+	metrics.Guilds.Set(float64(guildCount(session)))
+}
+
+// serveMetrics serves the metrics over HTTP at /metrics, until the context is
+// done.
+func (env *LookoutEnv) serveMetrics(ctx context.Context) {
+	addr := env.Env.Config.MetricsAddress
+	if addr == "" {
+		addr = defaultMetricsAddress
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	env.Env.Log.Info(
+		"Serving metrics.",
+		zap.String("address", addr))
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		env.Env.Log.Error(
+			"Error serving metrics.",
+			zap.Error(err))
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// The number of messages the outbox sends at once, and how many it holds
+// before ticks wait on it.
+const (
+	outboxWorkers = 4
+	outboxSize    = 256
+)
+
+// outbox sends messages in the background, so that ticks don't wait on
+// Discord, while keeping track of them so they can be drained on shutdown.
+type outbox struct {
+	queue chan func()
+	// Closed as draining starts, to free sends waiting on a full queue.
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+	// Held for sending, and taken exclusively to close the queue.
+	lock   sync.RWMutex
+	closed bool
+}
+
+func newOutbox() *outbox {
+	o := &outbox{queue: make(chan func(), outboxSize), stop: make(chan struct{})}
+	o.wg.Add(outboxWorkers)
+	for i := 0; i < outboxWorkers; i++ {
+		go func() {
+			defer o.wg.Done()
+			for send := range o.queue {
+				send()
+			}
+		}()
+	}
+	return o
+}
+
+// send queues the function to be run in the background. It reports false if
+// the outbox has been drained, and the function was dropped.
+func (o *outbox) send(fn func()) bool {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	if o.closed {
+		return false
+	}
+	select {
+	case o.queue <- fn:
+		return true
+	case <-o.stop:
+		return false
+	}
+}
+
+// drain stops the outbox taking more, and waits for what's queued to be
+// sent, up to the timeout. It reports whether everything was sent.
+func (o *outbox) drain(timeout time.Duration) bool {
+	o.stopOnce.Do(func() {
+		close(o.stop)
+	})
+	o.lock.Lock()
+	if !o.closed {
+		o.closed = true
+		close(o.queue)
+	}
+	o.lock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		o.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dg "github.com/bwmarrin/discordgo"
+)
+
+const (
+	replPrompt  = "lookout> "
+	replChannel = "repl"
+	replGuild   = "repl"
+	replUser    = "repl"
+)
+
+var replHelp = `REPL commands:
+  tick                  Run a tick now, rather than waiting for the audit period.
+  audit                 List the servers in the audit and their group counts.
+  audit [server]        List the groups of a server in the audit.
+  audit [server] [id]   Print a group of the audit as JSON.
+  exit                  Leave the REPL.
+Anything else is run as a bot command, with or without the prefix.`
+
+// consoleSession stands in for a Discord session, writing everything that
+// would be sent to a channel to out instead.
+type consoleSession struct {
+	out  io.Writer
+	lock sync.Mutex
+	// The ID of the last message sent.
+	lastID int
+}
+
+func (c *consoleSession) ChannelMessageSend(channelID string, content string) (*dg.Message, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	fmt.Fprintf(c.out, "[%s] %s\n", channelID, content)
+	c.lastID++
+	return &dg.Message{ID: fmt.Sprint(c.lastID), ChannelID: channelID, Content: content}, nil
+}
+
+func (c *consoleSession) ChannelMessageSendEmbed(channelID string, embed *dg.MessageEmbed) (*dg.Message, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	fmt.Fprintf(c.out, "[%s] ", channelID)
+	c.printEmbed(embed)
+	c.lastID++
+	return &dg.Message{ID: fmt.Sprint(c.lastID), ChannelID: channelID, Embeds: []*dg.MessageEmbed{embed}}, nil
+}
+
+func (c *consoleSession) ChannelMessageEditEmbed(channelID, messageID string, embed *dg.MessageEmbed) (*dg.Message, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	fmt.Fprintf(c.out, "[%s] (edited %s) ", channelID, messageID)
+	c.printEmbed(embed)
+	return &dg.Message{ID: messageID, ChannelID: channelID, Embeds: []*dg.MessageEmbed{embed}}, nil
+}
+
+func (c *consoleSession) ChannelMessageDelete(channelID, messageID string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	fmt.Fprintf(c.out, "[%s] (deleted %s)\n", channelID, messageID)
+	return nil
+}
+
+func (c *consoleSession) printEmbed(embed *dg.MessageEmbed) {
+	fmt.Fprintf(c.out, "== %s ==\n", embed.Title)
+	if embed.Description != "" {
+		fmt.Fprintln(c.out, embed.Description)
+	}
+	for _, field := range embed.Fields {
+		fmt.Fprintf(c.out, "-- %s\n%s\n", field.Name, field.Value)
+	}
+	if embed.Footer != nil {
+		fmt.Fprintf(c.out, "(%s)\n", embed.Footer.Text)
+	}
+}
+
+func (c *consoleSession) ChannelMessageSendComplex(channelID string, data *dg.MessageSend) (*dg.Message, error) {
+	if data.Content != "" {
+		c.ChannelMessageSend(channelID, data.Content)
+	}
+	if data.Embed != nil {
+		c.ChannelMessageSendEmbed(channelID, data.Embed)
+	}
+	for _, file := range data.Files {
+		n, _ := io.Copy(ioutil.Discard, file.Reader)
+		c.ChannelMessageSend(channelID, fmt.Sprintf("(attached %s, %d bytes)", file.Name, n))
+	}
+	return &dg.Message{ChannelID: channelID, Content: data.Content}, nil
+}
+
+// The REPL's user is treated as having every permission.
+func (c *consoleSession) UserChannelPermissions(userID, channelID string) (int, error) {
+	return dg.PermissionAll, nil
+}
+
+// Every channel mentioned in the REPL is taken to be in its server.
+func (c *consoleSession) Channel(channelID string) (*dg.Channel, error) {
+	return &dg.Channel{ID: channelID, GuildID: replGuild}, nil
+}
+
+// runRepl runs the audit loop without a Discord connection, reading commands
+// from in and writing replies and notifications to out until in is exhausted
+// or the user exits.
+func (env *LookoutEnv) runRepl(in io.Reader, out io.Writer) {
+	console := &consoleSession{out: out}
+	// The REPL's user runs the bot, so is its owner.
+	env.Env.Config.OwnerID = replUser
+	sched := env.newScheduler(console)
+	ctx, cancel := context.WithCancel(context.Background())
+	env.background(func() {
+		sched.Run(ctx)
+	})
+	env.background(func() {
+		env.maintain(ctx)
+	})
+	if env.Env.Config.MetricsEnabled {
+		env.background(func() {
+			env.serveMetrics(ctx)
+		})
+	}
+	defer env.shutdown(console, cancel)
+
+	fmt.Fprintln(out, "Lookout REPL. Type \"help\" for bot commands, or \"repl\" for REPL commands.")
+	scanner := bufio.NewScanner(in)
+	fmt.Fprint(out, replPrompt)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "exit" || fields[0] == "quit":
+			return
+		case fields[0] == "repl":
+			fmt.Fprintln(out, replHelp)
+		case fields[0] == "tick":
+			sched.Step(ctx)
+		case fields[0] == "audit":
+			console.lock.Lock()
+			env.printAudit(out, fields[1:])
+			console.lock.Unlock()
+		default:
+			prefix := env.Env.Config.Prefix
+			if settings, err := env.Env.Repo.FindGuildSettings(replGuild); err == nil && settings.Prefix != "" {
+				prefix = settings.Prefix
+			}
+			if !strings.HasPrefix(line, prefix) {
+				line = prefix + line
+			}
+			env.dispatch(console, replMessage(line))
+		}
+		fmt.Fprint(out, replPrompt)
+	}
+}
+
+// replMessage wraps a line of input as though it were a message sent to the
+// bot on Discord.
+func replMessage(content string) *dg.MessageCreate {
+	return &dg.MessageCreate{Message: &dg.Message{
+		ChannelID: replChannel,
+		GuildID:   replGuild,
+		Content:   content,
+		Author:    &dg.User{ID: replUser, Username: replUser},
+	}}
+}
+
+// printAudit writes the part of the audit map selected by args to out.
+func (env *LookoutEnv) printAudit(out io.Writer, args []string) {
+	auditMap := env.Env.Snapshot().Audit.Map
+	switch len(args) {
+	case 0:
+		servers := make([]string, 0, len(auditMap))
+		for server := range auditMap {
+			servers = append(servers, server)
+		}
+		sort.Strings(servers)
+		for _, server := range servers {
+			fmt.Fprintf(out, "%s: %d group(s)\n", server, len(auditMap[server]))
+		}
+	case 1:
+		serverMap, ok := auditMap[strings.Title(strings.ToLower(args[0]))]
+		if !ok {
+			fmt.Fprintln(out, "A server with that name was not found.")
+			return
+		}
+		ids := make([]string, 0, len(serverMap))
+		for id := range serverMap {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			sGroup := serverMap[id]
+			fmt.Fprintf(out, "%s (changed: %s)\n%s\n", id, sGroup.Changed.Format(time.Kitchen), sGroup.String())
+		}
+	default:
+		sGroup, ok := auditMap[strings.Title(strings.ToLower(args[0]))][args[1]]
+		if !ok {
+			fmt.Fprintln(out, "A group with that server and ID was not found.")
+			return
+		}
+		printJSON(out, sGroup)
+	}
+}
+
+func printJSON(out io.Writer, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return
+	}
+	fmt.Fprintln(out, string(b))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"lfm_lookout/internal/botcmds"
+	"lfm_lookout/internal/botenv"
+
+	"context"
+	"sync/atomic"
+	"time"
+
+	dg "github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// The longest shutdown waits for queued messages to be sent.
+const drainTimeout = time.Second * 10
+
+// background runs the function in a goroutine which shutdown waits out.
+func (env *LookoutEnv) background(fn func()) {
+	env.running.Add(1)
+	go func() {
+		defer env.running.Done()
+		fn()
+	}()
+}
+
+// tracking is middleware counting the commands running, for shutdown to wait
+// out, and refusing commands once shutting down.
+func (env *LookoutEnv) tracking() botcmds.Middleware {
+	return func(name string, command botcmds.Command) botcmds.Handler {
+		return func(session botcmds.Session, message *dg.MessageCreate, botEnv *botenv.BotEnv) {
+			if !env.startCommand() {
+				return
+			}
+			defer env.commands.Done()
+			command.Cmd(session, message, botEnv)
+		}
+	}
+}
+
+// startCommand counts a command as running, unless shutting down.
+func (env *LookoutEnv) startCommand() bool {
+	env.closeLock.RLock()
+	defer env.closeLock.RUnlock()
+	if atomic.LoadInt32(&env.closing) == 1 {
+		return false
+	}
+	env.commands.Add(1)
+	return true
+}
+
+// shutdown stops the bot in order: commands are refused, the tick in
+// progress and other background work is canceled, the commands running are
+// waited out, and the queued messages are sent, for up to drainTimeout. Then the session is closed, if it's
+// Discord's, followed by the store and archive, and the log is flushed.
+func (env *LookoutEnv) shutdown(session botcmds.Session, cancel context.CancelFunc) {
+	botEnv := env.Env
+	botEnv.Log.Info("Shutting down.")
+	env.closeLock.Lock()
+	atomic.StoreInt32(&env.closing, 1)
+	env.closeLock.Unlock()
+	bot, isBot := session.(*dg.Session)
+	if isBot {
+		err := bot.UpdateStatusComplex(dg.UpdateStatusData{
+			Status: "dnd",
+			Game:   &dg.Game{Name: "going offline", Type: dg.GameTypeGame},
+		})
+		if err != nil {
+			botEnv.Log.Warn(
+				"Error updating the presence.",
+				zap.Error(err))
+		}
+	}
+	cancel()
+	env.running.Wait()
+	env.commands.Wait()
+	if !env.outbox.drain(drainTimeout) {
+		botEnv.Log.Warn(
+			"Gave up on sending the queued messages.",
+			zap.Duration("timeout", drainTimeout))
+	}
+	if isBot {
+		bot.Close()
+	}
+	botEnv.Repo.Close()
+	if botEnv.Archive != nil {
+		if err := botEnv.Archive.Close(); err != nil {
+			botEnv.Log.Error(
+				"Error closing the archive.",
+				zap.Error(err))
+		}
+	}
+	botEnv.Log.Info("Shut down.")
+	botEnv.Log.Sync()
+}
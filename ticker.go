@@ -0,0 +1,296 @@
+package main
+
+import (
+	"lfm_lookout/internal/botcmds"
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/lodb"
+	"lfm_lookout/internal/matcher"
+	"lfm_lookout/internal/metrics"
+	"lfm_lookout/internal/scheduler"
+
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	dg "github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// The most matches a query is notified of per tick.
+const searchSize = scheduler.SearchSize
+
+// sessionNotifier queues a tick's notifications to be sent through a
+// session.
+type sessionNotifier struct {
+	env     *LookoutEnv
+	session botcmds.Session
+}
+
+func (n sessionNotifier) Notify(note scheduler.Notification) {
+	if note.Message != "" {
+		n.env.queueMessage(n.session, note.Return, note.Message)
+		return
+	}
+	n.env.queueMatches(n.session, note.Return, note.Label, note.Matches, botenv.StaleNote(note.Stale))
+}
+
+// queueMatches queues the matches to be sent by the outbox.
+func (env *LookoutEnv) queueMatches(session botcmds.Session, ret lodb.Return, label string, matches []matcher.SearchableGroup, note string) {
+	send := matchSender(session, ret, label, matches, note)
+	env.queue(func() {
+		env.deliver(send, 0)
+	})
+}
+
+// queueMessage queues the message to be sent by the outbox.
+func (env *LookoutEnv) queueMessage(session botcmds.Session, ret lodb.Return, message string) {
+	env.queue(func() {
+		env.deliver(func() error {
+			return sendMessage(session, ret, message)
+		}, 0)
+	})
+}
+
+// queue hands the send to the outbox, logging it if dropped.
+func (env *LookoutEnv) queue(send func()) bool {
+	if !env.outbox.send(send) {
+		env.Env.Log.Warn("Message dropped, as the bot is shutting down.")
+		return false
+	}
+	return true
+}
+
+// The number of times a notification is retried when Discord has trouble
+// sending it, and how long to wait before the first retry, doubling after.
+const (
+	sendRetries = 2
+	retryDelay  = time.Second * 2
+)
+
+// deliver makes the given try at sending a notification, and counts whether
+// it was sent. A send which may yet succeed is queued again after a delay,
+// rather than holding up the outbox, and is dropped if the outbox is drained
+// in the meantime.
+func (env *LookoutEnv) deliver(send func() error, try int) {
+	err := send()
+	if err != nil && retryable(err) && try < sendRetries {
+		metrics.Notifications.WithLabelValues(metrics.Retried).Inc()
+		time.AfterFunc(retryDelay<<try, func() {
+			if !env.queue(func() { env.deliver(send, try+1) }) {
+				metrics.Notifications.WithLabelValues(metrics.Failed).Inc()
+			}
+		})
+		return
+	}
+	if err != nil {
+		metrics.Notifications.WithLabelValues(metrics.Failed).Inc()
+		env.Env.Log.Warn(
+			"Error sending a notification.",
+			zap.Error(err))
+		return
+	}
+	metrics.Notifications.WithLabelValues(metrics.Sent).Inc()
+}
+
+// retryable reports whether a failed send may succeed if tried again, as
+// when Discord errs or is unreachable, rather than refusing the message.
+// Rate limits are already waited out, and retried, by discordgo.
+func retryable(err error) bool {
+	var restErr *dg.RESTError
+	if errors.As(err, &restErr) && restErr.Response != nil {
+		return restErr.Response.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// newScheduler builds the scheduler which ticks on behalf of the session,
+// with the archive, boards, watchlists and tracked groups following along.
+func (env *LookoutEnv) newScheduler(session botcmds.Session) *scheduler.Scheduler {
+	botEnv := env.Env
+	return &scheduler.Scheduler{
+		Env:       botEnv,
+		Clock:     scheduler.RealClock(),
+		Source:    env.Poller,
+		Store:     botEnv.Repo,
+		Matcher:   scheduler.BleveMatcher,
+		Notifier:  sessionNotifier{env: env, session: session},
+		Workers:   botEnv.Config.MatchWorkers,
+		SlowQuery: time.Millisecond * time.Duration(botEnv.Config.SlowQueryMillis),
+		Strikes:   botEnv.Config.QueryStrikes,
+		Observers: []scheduler.Observer{
+			env.archiveAudit,
+			func(t scheduler.Tick) {
+				env.updateBoards(session, t.Index)
+			},
+			func(t scheduler.Tick) {
+				env.updatePresence(session, t)
+			},
+			func(t scheduler.Tick) {
+				env.recordMetrics(session, t)
+			},
+			func(t scheduler.Tick) {
+				env.logStatistics(session, t,
+					env.runFriends(session, t.Prev, t.Curr),
+					env.runTracks(session, t.Prev, t.Curr))
+			},
+		},
+	}
+}
+
+// archiveAudit records the tick's audit in the archive, if enabled.
+func (env *LookoutEnv) archiveAudit(t scheduler.Tick) {
+	botEnv := env.Env
+	if botEnv.Archive == nil || t.Audit == nil {
+		return
+	}
+	if err := botEnv.Archive.Record(t.Audit, t.Time); err != nil {
+		botEnv.Log.Error(
+			"Error archiving the audit.",
+			zap.Error(err))
+	}
+}
+
+// updatePresence shows the health of the audit in the bot's presence, when it
+// has changed.
+func (env *LookoutEnv) updatePresence(session botcmds.Session, t scheduler.Tick) {
+	bot, ok := session.(*dg.Session)
+	if !ok {
+		return
+	}
+	health := env.Poller.Health()
+	status, activity := "online", ""
+	switch age := env.Env.Stale(t.Time); {
+	case health.State == scheduler.BreakerOpen:
+		status, activity = "dnd", "DDO groups unavailable"
+	case age > 0:
+		status, activity = "idle", fmt.Sprintf("groups %dm old", int(age.Minutes()))
+	case health.State == scheduler.Degraded:
+		status, activity = "idle", "DDO groups degraded"
+	}
+	presence := status + activity
+	if presence == env.presence {
+		return
+	}
+	data := dg.UpdateStatusData{Status: status}
+	if activity != "" {
+		data.Game = &dg.Game{Name: activity, Type: dg.GameTypeWatching}
+	}
+	if err := bot.UpdateStatusComplex(data); err != nil {
+		env.Env.Log.Warn(
+			"Error updating the presence.",
+			zap.Error(err))
+		return
+	}
+	env.presence = presence
+	env.Env.Log.Info(
+		"Presence updated.",
+		zap.String("state", health.State.String()),
+		zap.Int("failures", health.Failures))
+}
+
+// logStatistics logs some of the bot's stats.
+func (env *LookoutEnv) logStatistics(session botcmds.Session, t scheduler.Tick, watchlists, tracked int) {
+	env.Env.Log.Info(
+		"Bot Statistics",
+		zap.Int("guilds", guildCount(session)),
+		zap.Int("queries", t.Queries),
+		zap.Int("watches", t.Watches),
+		zap.Int("watchlists", watchlists),
+		zap.Int("tracked", tracked))
+}
+
+// guildCount gives the number of servers the session's bot is in.
+func guildCount(session botcmds.Session) int {
+	bot, ok := session.(*dg.Session)
+	if !ok {
+		return 0
+	}
+	bot.State.RLock()
+	defer bot.State.RUnlock()
+	return len(bot.State.Guilds)
+}
+
+// The most characters Discord takes in a message.
+const messageMax = 2000
+
+// matchSender gives a send of the groups matched by a query, in the style of
+// its return, labelling each with the query's label. The note, if any, is
+// sent along with them. Plain text too long for one message is sent as
+// several, and a send tried again resumes with the first not yet sent.
+func matchSender(session botcmds.Session, ret lodb.Return, label string, matches []matcher.SearchableGroup, note string) func() error {
+	if ret.Style == lodb.StyleEmbed {
+		// Embeds are limited to 25 fields.
+		if len(matches) > 25 {
+			matches = matches[:25]
+		}
+		fields := make([]*dg.MessageEmbedField, len(matches))
+		for i, sGroup := range matches {
+			fields[i] = &dg.MessageEmbedField{
+				Name:  sGroup.Server,
+				Value: sGroup.String(),
+			}
+		}
+		embed := &dg.MessageEmbed{
+			Title:       label,
+			Description: note,
+			Fields:      fields,
+		}
+		return func() error {
+			_, err := session.ChannelMessageSendComplex(ret.ChannelID, &dg.MessageSend{Content: ret.Mention, Embed: embed})
+			return err
+		}
+	}
+	var parts []string
+	if ret.Mention != "" {
+		parts = append(parts, ret.Mention+"\n")
+	}
+	for _, sGroup := range matches {
+		parts = append(parts, fmt.Sprintf("**%s**, %s\n%s\n", label, sGroup.Server, sGroup.String()))
+	}
+	if note != "" {
+		parts = append(parts, note+"\n")
+	}
+	messages := splitMessages(parts)
+	sent := 0
+	return func() error {
+		for ; sent < len(messages); sent++ {
+			if _, err := session.ChannelMessageSend(ret.ChannelID, messages[sent]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// splitMessages joins the parts into as few messages as fit Discord's limit,
+// without splitting a part. Parts too long for a message alone are cut short.
+func splitMessages(parts []string) []string {
+	var messages []string
+	var b strings.Builder
+	for _, part := range parts {
+		if len(part) > messageMax {
+			part = strings.ToValidUTF8(part[:messageMax-len("...")], "") + "..."
+		}
+		if b.Len()+len(part) > messageMax {
+			messages = append(messages, b.String())
+			b.Reset()
+		}
+		b.WriteString(part)
+	}
+	if b.Len() > 0 {
+		messages = append(messages, b.String())
+	}
+	return messages
+}
+
+// sendMessage sends a message to where a query's matches are sent, returning
+// any error sending it.
+func sendMessage(session botcmds.Session, ret lodb.Return, message string) error {
+	if ret.Mention != "" {
+		message = fmt.Sprintf("%s\n%s", ret.Mention, message)
+	}
+	_, err := session.ChannelMessageSend(ret.ChannelID, message)
+	return err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"lfm_lookout/internal/botcmds"
+	"lfm_lookout/internal/botenv"
+	"lfm_lookout/internal/lodb"
+	"lfm_lookout/internal/matcher"
+
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// runTracks notifies users of what has changed in the groups they track
+// since the previous audit, and stops tracking groups which have filled,
+// started or disbanded. It returns the number of tracked groups.
+func (env *LookoutEnv) runTracks(session botcmds.Session, prevAudit, currAudit botenv.AuditMap) int {
+	botEnv := env.Env
+	tracks, err := botEnv.Repo.FindTracks()
+	if err != nil {
+		botEnv.Log.Error(
+			"Error while retrieving tracked groups.",
+			zap.Error(err))
+		return 0
+	}
+	if len(tracks) == 0 {
+		return 0
+	}
+	optOuts, err := botEnv.Repo.FindOptOuts()
+	if err != nil {
+		botEnv.Log.Error(
+			"Error while retrieving opt-outs.",
+			zap.Error(err))
+		return len(tracks)
+	}
+	for _, t := range tracks {
+		id := fmt.Sprintf("%d", t.GroupID)
+		prev, wasListed := prevAudit.Map[t.Server][id]
+		curr, listed := currAudit.Map[t.Server][id]
+		if !listed {
+			if wasListed {
+				env.queueMessage(session, t.Return(), fmt.Sprintf("**Group #%d**, %s has disbanded, and is no longer tracked.",
+					t.GroupID, t.Server))
+			}
+			env.stopTrack(t)
+			continue
+		}
+		if !wasListed {
+			continue
+		}
+		changes, done := groupChanges(prev, curr, optOuts)
+		if len(changes) == 0 {
+			continue
+		}
+		label := fmt.Sprintf("Group #%d: %s", t.GroupID, strings.Join(changes, ", "))
+		env.queueMatches(session, t.Return(), label, []matcher.SearchableGroup{curr}, "")
+		if done {
+			env.stopTrack(t)
+		}
+	}
+	return len(tracks)
+}
+
+func (env *LookoutEnv) stopTrack(t lodb.Track) {
+	if err := env.Env.Repo.DeleteTrack(t); err != nil && err != lodb.ErrTrackNotFound {
+		env.Env.Log.Error(
+			"Error deleting tracked group.",
+			zap.Error(err))
+	}
+}
+
+// groupChanges describes what changed between two audits of a group, and
+// whether the group is done with, having filled or started. Players who have
+// opted out of being watched aren't named.
+func groupChanges(prev, curr matcher.SearchableGroup, optOuts lodb.OptOuts) ([]string, bool) {
+	var changes []string
+	joined, left := memberChanges(prev, curr)
+	name := func(n string) string {
+		if n == "" || optOuts.Has(curr.Server, n) {
+			return "a player"
+		}
+		return n
+	}
+	for _, n := range joined {
+		changes = append(changes, name(n)+" joined")
+	}
+	for _, n := range left {
+		changes = append(changes, name(n)+" left")
+	}
+	if curr.Group.Comment != prev.Group.Comment {
+		changes = append(changes, fmt.Sprintf("comment changed to \"%s\"", curr.Group.Comment))
+	}
+	var done bool
+	if curr.Group.AdventureActive > 0 && prev.Group.AdventureActive == 0 {
+		changes = append(changes, "started, and is no longer tracked")
+		done = true
+	} else if curr.IsFull() && !prev.IsFull() {
+		changes = append(changes, "filled, and is no longer tracked")
+		done = true
+	}
+	return changes, done
+}
+
+// memberChanges returns the names of the players who joined and left the
+// group, with empty names for players whose names aren't known.
+func memberChanges(prev, curr matcher.SearchableGroup) ([]string, []string) {
+	counts := make(map[string]int)
+	add := func(g matcher.SearchableGroup, n int) {
+		counts[g.Group.Leader.Name] += n
+		for _, m := range g.Group.Members {
+			counts[m.Name] += n
+		}
+	}
+	add(curr, 1)
+	add(prev, -1)
+	var joined, left []string
+	for name, n := range counts {
+		for ; n > 0; n-- {
+			joined = append(joined, name)
+		}
+		for ; n < 0; n++ {
+			left = append(left, name)
+		}
+	}
+	sort.Strings(joined)
+	sort.Strings(left)
+	return joined, left
+}